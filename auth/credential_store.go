@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+// credentialSweepInterval is how often expired secrets are removed from the lookup credential store
+const credentialSweepInterval = time.Minute
+
+var (
+	ErrUnknownKeyID            = errors.New("unknown access key id")
+	ErrUnknownCredentialStore  = errors.New("unknown credential store")
+	ErrInvalidCredentialFormat = errors.New("invalid credential format")
+)
+
+type (
+	// CredentialStore turns an access key ID into the secrets that are allowed to sign for it.
+	// Multiple secrets may be active at once so keys can be rotated without downtime.
+	CredentialStore interface {
+		// GetSecrets returns ErrUnknownKeyID if the key does not exist
+		GetSecrets(ctx context.Context, keyID string) ([]string, error)
+	}
+
+	// StaticCredentialStore is an in-memory map of key ID to secrets, loaded from an env var or a file
+	StaticCredentialStore struct {
+		keys map[string][]string
+	}
+
+	// LookupCredentialStore asks the lookup API for the secrets, caching them locally for CACHE_SECONDS.
+	// Unknown keys are cached for CACHE_NEGATIVE_SECONDS, so bogus key IDs don't each call the lookup API.
+	LookupCredentialStore struct {
+		cache       sync.Map // key ID -> cachedSecrets
+		lastSweepMS atomic.Int64
+	}
+
+	cachedSecrets struct {
+		// nil for unknown keys
+		secrets []string
+		expires time.Time
+	}
+)
+
+// NewCredentialStoreFromEnv creates the credential store selected by CREDENTIAL_STORE
+func NewCredentialStoreFromEnv() (CredentialStore, error) {
+	switch utils.CredentialStore {
+	case "env":
+		return NewEnvCredentialStore(utils.Credentials)
+	case "file":
+		return NewFileCredentialStore(utils.CredentialsFile)
+	case "lookup":
+		return &LookupCredentialStore{}, nil
+	default:
+		return nil, fmt.Errorf("store '%s': %w", utils.CredentialStore, ErrUnknownCredentialStore)
+	}
+}
+
+// NewEnvCredentialStore parses credentials in the format `keyID:secret1|secret2,keyID2:secret3`
+func NewEnvCredentialStore(creds string) (*StaticCredentialStore, error) {
+	store := &StaticCredentialStore{
+		keys: map[string][]string{},
+	}
+	for _, cred := range strings.Split(creds, ",") {
+		if cred == "" {
+			continue
+		}
+		keyID, secrets, found := strings.Cut(cred, ":")
+		if !found || keyID == "" || secrets == "" {
+			return nil, fmt.Errorf("credential for key '%s': %w", keyID, ErrInvalidCredentialFormat)
+		}
+		store.keys[keyID] = append(store.keys[keyID], strings.Split(secrets, "|")...)
+	}
+	return store, nil
+}
+
+// NewFileCredentialStore loads a JSON file in the format `{"keyID": ["secret1", "secret2"]}`
+func NewFileCredentialStore(fileName string) (*StaticCredentialStore, error) {
+	fileBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error in os.ReadFile: %w", err)
+	}
+	store := &StaticCredentialStore{
+		keys: map[string][]string{},
+	}
+	err = sonic.Unmarshal(fileBytes, &store.keys)
+	if err != nil {
+		return nil, fmt.Errorf("error in sonic.Unmarshal: %w", err)
+	}
+	return store, nil
+}
+
+func (s *StaticCredentialStore) GetSecrets(_ context.Context, keyID string) ([]string, error) {
+	secrets, exists := s.keys[keyID]
+	if !exists || len(secrets) == 0 {
+		return nil, ErrUnknownKeyID
+	}
+	return secrets, nil
+}
+
+func (s *LookupCredentialStore) GetSecrets(ctx context.Context, keyID string) ([]string, error) {
+	if cached, ok := s.cache.Load(keyID); ok && time.Now().Before(cached.(cachedSecrets).expires) {
+		if cached.(cachedSecrets).secrets == nil {
+			return nil, ErrUnknownKeyID
+		}
+		return cached.(cachedSecrets).secrets, nil
+	}
+
+	secrets, err := lookup.ResolveKeySecrets(ctx, keyID)
+	if errors.Is(err, lookup.ErrLookupNotFound) || (err == nil && len(secrets) == 0) {
+		s.store(keyID, nil, utils.CacheNegativeSeconds)
+		return nil, ErrUnknownKeyID
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in lookup.ResolveKeySecrets: %w", err)
+	}
+
+	s.store(keyID, secrets, utils.CacheTTLSeconds)
+	return secrets, nil
+}
+
+// store caches the secrets for ttlSeconds, sweeping expired secrets at most every credentialSweepInterval
+func (s *LookupCredentialStore) store(keyID string, secrets []string, ttlSeconds int64) {
+	now := time.Now()
+	if lastSweepMS := s.lastSweepMS.Load(); now.Sub(time.UnixMilli(lastSweepMS)) > credentialSweepInterval &&
+		s.lastSweepMS.CompareAndSwap(lastSweepMS, now.UnixMilli()) {
+		s.cache.Range(func(key, value any) bool {
+			if now.After(value.(cachedSecrets).expires) {
+				s.cache.Delete(key)
+			}
+			return true
+		})
+	}
+
+	s.cache.Store(keyID, cachedSecrets{
+		secrets: secrets,
+		expires: now.Add(time.Second * time.Duration(ttlSeconds)),
+	})
+}
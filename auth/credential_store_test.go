@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+func TestEnvCredentialStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewEnvCredentialStore("AKID:s1|s2,OTHER:s3,AKID:s4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := store.GetSecrets(ctx, "AKID")
+	if err != nil || !slices.Equal(secrets, []string{"s1", "s2", "s4"}) {
+		t.Fatalf("got %v, %v", secrets, err)
+	}
+	if secrets, err = store.GetSecrets(ctx, "OTHER"); err != nil || !slices.Equal(secrets, []string{"s3"}) {
+		t.Fatalf("got %v, %v", secrets, err)
+	}
+	if _, err = store.GetSecrets(ctx, "UNKNOWN"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("expected unknown key, got %v", err)
+	}
+
+	for _, creds := range []string{"AKID", "AKID:", ":secret"} {
+		if _, err = NewEnvCredentialStore(creds); !errors.Is(err, ErrInvalidCredentialFormat) {
+			t.Fatalf("expected invalid format for %q, got %v", creds, err)
+		}
+	}
+}
+
+func TestFileCredentialStore(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "creds.json")
+	if err := os.WriteFile(fileName, []byte(`{"AKID": ["s1", "s2"], "EMPTY": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileCredentialStore(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := store.GetSecrets(ctx, "AKID")
+	if err != nil || !slices.Equal(secrets, []string{"s1", "s2"}) {
+		t.Fatalf("got %v, %v", secrets, err)
+	}
+	// A key without secrets can't sign anything
+	if _, err = store.GetSecrets(ctx, "EMPTY"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("expected unknown key, got %v", err)
+	}
+	if _, err = NewFileCredentialStore(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestLookupCredentialStore(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/resolve_key":
+			w.Write([]byte(`{"Secrets": ["s1", "s2"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	lookupURL, cacheTTL := utils.LookupURL, utils.CacheTTLSeconds
+	t.Cleanup(func() {
+		utils.LookupURL, utils.CacheTTLSeconds = lookupURL, cacheTTL
+	})
+	utils.LookupURL, utils.CacheTTLSeconds = server.URL, 60
+
+	ctx := context.Background()
+	store := &LookupCredentialStore{}
+	for i := 0; i < 3; i++ {
+		secrets, err := store.GetSecrets(ctx, "AKID")
+		if err != nil || !slices.Equal(secrets, []string{"s1", "s2"}) {
+			t.Fatalf("got %v, %v", secrets, err)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("expected the secrets to be cached, got %d lookups", requests.Load())
+	}
+}
+
+func TestLookupCredentialStoreUnknownKey(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req lookup.KeySecretsReq
+		if err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&req); err == nil && req.KeyID == "EMPTY" {
+			w.Write([]byte(`{"Secrets": []}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	lookupURL, negativeTTL := utils.LookupURL, utils.CacheNegativeSeconds
+	t.Cleanup(func() {
+		utils.LookupURL, utils.CacheNegativeSeconds = lookupURL, negativeTTL
+	})
+	utils.LookupURL, utils.CacheNegativeSeconds = server.URL, 60
+
+	store := &LookupCredentialStore{}
+	for _, keyID := range []string{"UNKNOWN", "EMPTY"} {
+		// Repeats are negative cached, so don't each call the lookup
+		for i := 0; i < 3; i++ {
+			if _, err := store.GetSecrets(context.Background(), keyID); !errors.Is(err, ErrUnknownKeyID) {
+				t.Fatalf("expected unknown key for %s, got %v", keyID, err)
+			}
+		}
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("expected a lookup per unknown key, got %d", requests.Load())
+	}
+}
+
+func TestLookupCredentialStoreSweep(t *testing.T) {
+	store := &LookupCredentialStore{}
+	store.cache.Store("EXPIRED", cachedSecrets{expires: time.Now().Add(-time.Second)})
+	store.store("AKID", []string{"s1"}, 60)
+	if _, exists := store.cache.Load("EXPIRED"); exists {
+		t.Fatal("expired secrets were not swept")
+	}
+
+	// Not swept again until the interval has passed
+	store.cache.Store("EXPIRED", cachedSecrets{expires: time.Now().Add(-time.Second)})
+	store.store("OTHER", []string{"s2"}, 60)
+	if _, exists := store.cache.Load("EXPIRED"); !exists {
+		t.Fatal("swept before the interval")
+	}
+	if _, exists := store.cache.Load("AKID"); !exists {
+		t.Fatal("unexpired secrets were swept")
+	}
+}
@@ -19,6 +19,18 @@ app.post('/resolve_virtual_bucket', async (req: Request<{}, VirtualBucket, {
     } as VirtualBucket)
 })
 
+app.post('/resolve_key', async (req: Request<{}, { Secrets: string[] }, {
+    KeyID: string
+}>, res) => {
+    console.log('resolving key', req.body.KeyID)
+    if (req.body.KeyID !== 'iceuser') {
+        return res.sendStatus(404)
+    }
+    res.json({
+        Secrets: ['icepassword']
+    })
+})
+
 app.listen('8888', () => {
     console.log('listening on port 8888')
 })
\ No newline at end of file
@@ -0,0 +1,52 @@
+package http_server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// checkConditions evaluates the conditional request headers against the ETag and LastModified derived from the
+// IceDB log, returning 304 or 412 if the request should not be served, otherwise 0. Follows RFC 9110 section 13.2.2.
+func checkConditions(header http.Header, etag string, lastModified time.Time) int {
+	// HTTP dates have second precision
+	lastModified = lastModified.Truncate(time.Second)
+
+	if ifMatch := header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(header.Get("If-Unmodified-Since")); err == nil && lastModified.After(since) {
+		return http.StatusPreconditionFailed
+	}
+
+	if ifNoneMatch := header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if since, err := http.ParseTime(header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagMatches checks a comma separated list of ETags (or *) with weak comparison
+func etagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches returns whether a Range should be honored, which is when there is no If-Range or it still matches
+func ifRangeMatches(header http.Header, etag string, lastModified time.Time) bool {
+	ifRange := header.Get("If-Range")
+	if ifRange == "" || ifRange == etag {
+		return true
+	}
+	since, err := http.ParseTime(ifRange)
+	return err == nil && !lastModified.Truncate(time.Second).After(since)
+}
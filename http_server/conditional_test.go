@@ -0,0 +1,41 @@
+package http_server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckConditions(t *testing.T) {
+	etag := `"abc"`
+	lastModified := time.UnixMilli(1700000000500).UTC()
+
+	tests := []struct {
+		header   http.Header
+		expected int
+	}{
+		{http.Header{}, 0},
+		{http.Header{"If-None-Match": {`"abc"`}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"def", W/"abc"`}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"def"`}}, 0},
+		// If-None-Match takes priority over If-Modified-Since
+		{http.Header{"If-None-Match": {`"def"`}, "If-Modified-Since": {lastModified.Format(http.TimeFormat)}}, 0},
+		{http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {lastModified.Add(-time.Second).Format(http.TimeFormat)}}, 0},
+		{http.Header{"If-Match": {`"def"`}}, http.StatusPreconditionFailed},
+		{http.Header{"If-Match": {`"abc"`}}, 0},
+		{http.Header{"If-Unmodified-Since": {lastModified.Add(-time.Second).Format(http.TimeFormat)}}, http.StatusPreconditionFailed},
+	}
+	for i, test := range tests {
+		if status := checkConditions(test.header, etag, lastModified); status != test.expected {
+			t.Fatalf("test %d expected %d got %d", i, test.expected, status)
+		}
+	}
+
+	if ifRangeMatches(http.Header{"If-Range": {`"def"`}}, etag, lastModified) {
+		t.Fatal("changed If-Range matched")
+	}
+	if !ifRangeMatches(http.Header{"If-Range": {`"abc"`}}, etag, lastModified) || !ifRangeMatches(http.Header{}, etag, lastModified) {
+		t.Fatal("If-Range did not match")
+	}
+}
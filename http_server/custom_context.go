@@ -3,10 +3,14 @@ package http_server
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/sigv4"
 	"github.com/danthegoodman1/GoAPITemplate/utils"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/danthegoodman1/GoAPITemplate/gologger"
 	"github.com/google/uuid"
@@ -14,11 +18,22 @@ import (
 	"github.com/rs/zerolog"
 )
 
+const (
+	// asOfSuffix selects a snapshot time through the bucket name, e.g. `mybucket--asof-1700000000000`
+	asOfSuffix = "--asof-"
+	// asOfHeader selects a snapshot time as unix ms or RFC3339, the bucket suffix takes priority
+	asOfHeader = "x-icedb-as-of"
+)
+
 type CustomContext struct {
 	echo.Context
 	RequestID, UserID, VirtualBucketName, RealBucketName string
-	AWSCredentials                                       AWSAuthHeaderCredential
-	IsPathRouting                                        bool
+	// The bucket name as the client sent it, including any as of suffix
+	RequestedBucketName string
+	AWSCredentials      sigv4.Credential
+	IsPathRouting       bool
+	// The snapshot time requested by the client, 0 if not requested
+	AsOfMS int64
 }
 
 func CreateReqContext(next echo.HandlerFunc) echo.HandlerFunc {
@@ -35,6 +50,7 @@ func CreateReqContext(next echo.HandlerFunc) echo.HandlerFunc {
 			Context:   c,
 			RequestID: reqID,
 		}
+		c.Response().Header().Set("x-amz-request-id", reqID)
 		return next(cc)
 	}
 }
@@ -46,7 +62,7 @@ func (srv *HTTPServer) ccHandler(h func(*CustomContext) error) echo.HandlerFunc
 		domainParts := strings.Split(c.Request().Host, ".")
 		if len(domainParts) > len(utils.MyURLParts) {
 			// vhost routing
-			cc.VirtualBucketName = domainParts[0]
+			cc.RequestedBucketName = domainParts[0]
 		} else {
 			// path routing, path style list possibly
 			u, err := url.Parse(c.Request().RequestURI)
@@ -54,17 +70,61 @@ func (srv *HTTPServer) ccHandler(h func(*CustomContext) error) echo.HandlerFunc
 				return cc.InternalError(err, "error in url.Parse")
 			}
 			pathParts := strings.Split(u.Path, "/")
-			cc.VirtualBucketName = pathParts[1]
+			cc.RequestedBucketName = pathParts[1]
 			cc.IsPathRouting = true
 		}
+
+		var asOf string
+		cc.VirtualBucketName, asOf, _ = strings.Cut(cc.RequestedBucketName, asOfSuffix)
+		if asOf == "" {
+			asOf = c.Request().Header.Get(asOfHeader)
+		}
+		if asOf != "" {
+			asOfMS, err := parseAsOf(asOf)
+			if err != nil {
+				return cc.S3Error(http.StatusBadRequest, "InvalidArgument", "The as of time must be unix milliseconds or RFC3339")
+			}
+			cc.AsOfMS = asOfMS
+		}
 		logger := zerolog.Ctx(cc.Request().Context())
 		logger.UpdateContext(func(c zerolog.Context) zerolog.Context {
-			return c.Str("VirtBucket", cc.VirtualBucketName)
+			return c.Str("VirtBucket", cc.VirtualBucketName).Int64("asOfMS", cc.AsOfMS)
 		})
 		return h(cc)
 	}
 }
 
+// ObjectKey is the key of the object within the virtual bucket
+func (c *CustomContext) ObjectKey() string {
+	key := strings.TrimPrefix(c.Request().URL.Path, "/")
+	if c.IsPathRouting {
+		// drop the `bucket/`
+		_, key, _ = strings.Cut(key, "/")
+	}
+	return key
+}
+
+// bucketURL is the URL of the virtual bucket on this proxy, as the client addressed it
+func (c *CustomContext) bucketURL() string {
+	bucketURL := c.Scheme() + "://" + c.Request().Host
+	if c.IsPathRouting {
+		bucketURL += "/" + c.RequestedBucketName
+	}
+	return bucketURL
+}
+
+// parseAsOf parses unix milliseconds or an RFC3339 time
+func parseAsOf(s string) (int64, error) {
+	if asOfMS, err := strconv.ParseInt(s, 10, 64); err == nil && asOfMS > 0 {
+		return asOfMS, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("error in time.Parse: %w", err)
+	}
+	return t.UnixMilli(), nil
+}
+
 func (c *CustomContext) internalErrorMessage() string {
 	return "internal error, request id: " + c.RequestID
 }
@@ -75,5 +135,5 @@ func (c *CustomContext) InternalError(err error, msg string) error {
 	} else {
 		zerolog.Ctx(c.Request().Context()).Error().CallerSkipFrame(1).Err(err).Msg(msg)
 	}
-	return c.String(http.StatusInternalServerError, c.internalErrorMessage())
+	return c.S3Error(http.StatusInternalServerError, "InternalError", c.internalErrorMessage())
 }
@@ -0,0 +1,129 @@
+package http_server
+
+import (
+	"errors"
+	"github.com/danthegoodman1/GoAPITemplate/icedb"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"net/http"
+	"strings"
+)
+
+// diffAPIKey lists the data files added and removed between two snapshot times
+const diffAPIKey = "_icedb/diff"
+
+type (
+	DiffRequest struct {
+		FromMS            *int64  `query:"from"`
+		ToMS              *int64  `query:"to"`
+		MaxKeys           *int64  `query:"max-keys"`
+		ContinuationToken *string `query:"continuation-token"`
+	}
+
+	DiffResponse struct {
+		FromMS                int64
+		ToMS                  int64
+		Added                 []DiffFile
+		Removed               []DiffFile
+		IsTruncated           bool
+		NextContinuationToken string `json:",omitempty"`
+	}
+
+	DiffFile struct {
+		Key         string
+		Size        int
+		TimestampMS int
+	}
+)
+
+func (srv *HTTPServer) GetDiff(c *CustomContext) error {
+	var req DiffRequest
+	if err := c.Bind(&req); err != nil {
+		return c.S3Error(http.StatusBadRequest, "InvalidArgument", "from, to, and max-keys must be integers")
+	}
+	maxKeys := max(min(utils.Deref(req.MaxKeys, 1000), 1000), 0)
+
+	resolvedBucket, err := lookup.ResolveVirtualBucket(c.Request().Context(), c.VirtualBucketName, c.AWSCredentials.KeyID)
+	if err != nil {
+		return c.LookupError(err)
+	}
+
+	// to defaults to the snapshot time the request would otherwise read, both are capped like time travel
+	dataPrefix := resolvedBucket.Prefix + "/_data/"
+	offset := ""
+	fromMS, toMS := utils.Deref(req.FromMS, 0), utils.Deref(req.ToMS, c.AsOfMS)
+	if req.ContinuationToken != nil {
+		token, err := decodeListToken(*req.ContinuationToken, c.VirtualBucketName, c.AWSCredentials.KeyID)
+		if err != nil {
+			return c.S3Error(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+		}
+		offset = dataPrefix + token.LastKey
+		fromMS, toMS = token.FromMS, token.TimeMS
+	}
+	if fromMS <= 0 {
+		return c.S3Error(http.StatusBadRequest, "InvalidArgument", "from must be a unix millisecond time")
+	}
+	fromMS, err = resolvedBucket.SnapshotTimeAsOf(fromMS)
+	if err == nil {
+		toMS, err = resolvedBucket.SnapshotTimeAsOf(toMS)
+	}
+	if err != nil {
+		return c.LookupError(err)
+	}
+	if fromMS > toMS {
+		return c.S3Error(http.StatusBadRequest, "InvalidArgument", "from must not be after to")
+	}
+
+	fromSnapshot, err := readDiffSnapshot(c, resolvedBucket, fromMS)
+	if err != nil {
+		return c.InternalError(err, "error in readDiffSnapshot for from")
+	}
+	toSnapshot, err := readDiffSnapshot(c, resolvedBucket, toMS)
+	if err != nil {
+		return c.InternalError(err, "error in readDiffSnapshot for to")
+	}
+
+	diff := icedb.Diff(fromSnapshot, toSnapshot, offset, maxKeys)
+	res := DiffResponse{
+		FromMS:  fromMS,
+		ToMS:    toMS,
+		Added:   diffFiles(diff.Added, dataPrefix),
+		Removed: diffFiles(diff.Removed, dataPrefix),
+	}
+	// With max-keys=0 there is no key to continue from, so it is not truncated (or clients would loop forever)
+	res.IsTruncated = diff.Truncated && diff.LastKey != ""
+	if res.IsTruncated {
+		res.NextContinuationToken, err = encodeListToken(listToken{
+			VirtualBucket: c.VirtualBucketName,
+			KeyID:         c.AWSCredentials.KeyID,
+			LastKey:       strings.TrimPrefix(diff.LastKey, dataPrefix),
+			TimeMS:        toMS,
+			FromMS:        fromMS,
+		})
+		if err != nil {
+			return c.InternalError(err, "error in encodeListToken")
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// readDiffSnapshot reads the snapshot at snapshotTimeMS, which is nil if there were no alive files yet
+func readDiffSnapshot(c *CustomContext, resolvedBucket *lookup.VirtualBucketResolveRes, snapshotTimeMS int64) (*icedb.LogSnapshot, error) {
+	snapshot, err := readSnapshot(c, resolvedBucket, snapshotTimeMS)
+	if errors.Is(err, icedb.ErrNoLogFiles) || errors.Is(err, icedb.ErrNoAliveFiles) {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+func diffFiles(markers []icedb.FileMarker, dataPrefix string) []DiffFile {
+	files := make([]DiffFile, 0, len(markers))
+	for _, marker := range markers {
+		files = append(files, DiffFile{
+			Key:         strings.TrimPrefix(marker.Path, dataPrefix),
+			Size:        marker.ByteLength,
+			TimestampMS: marker.TimestampMS,
+		})
+	}
+	return files
+}
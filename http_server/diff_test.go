@@ -0,0 +1,39 @@
+package http_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestDiffPagination(t *testing.T) {
+	useLocalTestTable(t)
+	srv := &HTTPServer{}
+	diff := func(query url.Values) DiffResponse {
+		rec := serveTestHandler(t, srv.GetDiff, "/bucket/"+diffAPIKey+"?"+query.Encode(), "AKID")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("diff failed %d: %s", rec.Code, rec.Body.String())
+		}
+		var res DiffResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// Nothing to continue from, so clients must not keep paginating
+	res := diff(url.Values{"from": {"1"}, "to": {"1700000000000"}, "max-keys": {"0"}})
+	if res.IsTruncated || res.NextContinuationToken != "" || len(res.Added) != 0 {
+		t.Fatalf("bad max-keys=0 result %+v", res)
+	}
+
+	res = diff(url.Values{"from": {"1"}, "to": {"1700000000000"}, "max-keys": {"2"}})
+	if !res.IsTruncated || res.NextContinuationToken == "" || len(res.Added) != 2 {
+		t.Fatalf("bad first page %+v", res)
+	}
+	res = diff(url.Values{"continuation-token": {res.NextContinuationToken}})
+	if res.IsTruncated || len(res.Added) != 1 || res.Added[0].Key != "c.parquet" {
+		t.Fatalf("bad second page %+v", res)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/danthegoodman1/GoAPITemplate/auth"
 	"github.com/danthegoodman1/GoAPITemplate/gologger"
 	"github.com/danthegoodman1/GoAPITemplate/utils"
 	"github.com/go-playground/validator/v10"
@@ -21,7 +22,8 @@ import (
 var logger = gologger.NewLogger()
 
 type HTTPServer struct {
-	Echo *echo.Echo
+	Echo            *echo.Echo
+	CredentialStore auth.CredentialStore
 }
 
 type CustomValidator struct {
@@ -34,12 +36,19 @@ func StartHTTPServer() *HTTPServer {
 		logger.Error().Err(err).Msg("error creating tcp listener, exiting")
 		os.Exit(1)
 	}
+	credentialStore, err := auth.NewCredentialStoreFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("error creating credential store, exiting")
+		os.Exit(1)
+	}
 	s := &HTTPServer{
-		Echo: echo.New(),
+		Echo:            echo.New(),
+		CredentialStore: credentialStore,
 	}
 	s.Echo.HideBanner = true
 	s.Echo.HidePort = true
 	s.Echo.JSONSerializer = &utils.NoEscapeJSONSerializer{}
+	s.Echo.HTTPErrorHandler = s.HTTPErrorHandler
 
 	s.Echo.Use(CreateReqContext)
 	s.Echo.Use(LoggerMiddleware)
@@ -47,9 +56,9 @@ func StartHTTPServer() *HTTPServer {
 	s.Echo.Validator = &CustomValidator{validator: validator.New()}
 
 	s.Echo.GET("/hc", s.HealthCheck)
-	s.Echo.GET("/", s.ccHandler(s.ListObjectInterceptor), verifyAWSRequest)
-	s.Echo.GET("/*", s.ccHandler(s.CheckListOrGetObject), verifyAWSRequest)
-	s.Echo.HEAD("/*", s.ccHandler(s.ProxyS3Request), verifyAWSRequest)
+	s.Echo.GET("/", s.ccHandler(s.ListObjectInterceptor), s.verifyAWSRequest)
+	s.Echo.GET("/*", s.ccHandler(s.CheckListOrGetObject), s.verifyAWSRequest)
+	s.Echo.HEAD("/*", s.ccHandler(s.GetObject), s.verifyAWSRequest)
 
 	s.Echo.Listener = listener
 	go func() {
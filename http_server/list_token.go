@@ -0,0 +1,58 @@
+package http_server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"strings"
+)
+
+var ErrInvalidListToken = errors.New("invalid continuation token")
+
+// listToken is the opaque ListObjectsV2 (and diff) continuation token. It pins the snapshot time so every page
+// reflects the same IceDB snapshot, and is signed so clients can't use it to time travel.
+type listToken struct {
+	VirtualBucket string `json:"b"`
+	// The lookup is per key, so another key can't use the token to read a snapshot it isn't allowed to
+	KeyID   string `json:"i"`
+	LastKey string `json:"k"`
+	TimeMS  int64  `json:"t"`
+	// The from snapshot time of a diff
+	FromMS int64 `json:"f,omitempty"`
+}
+
+func encodeListToken(token listToken) (string, error) {
+	jBytes, err := sonic.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("error in sonic.Marshal: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(jBytes)
+	return payload + "." + signListToken(payload), nil
+}
+
+func decodeListToken(s, virtualBucket, keyID string) (*listToken, error) {
+	payload, sig, found := strings.Cut(s, ".")
+	if !found || !hmac.Equal([]byte(sig), []byte(signListToken(payload))) {
+		return nil, ErrInvalidListToken
+	}
+	jBytes, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidListToken
+	}
+	var token listToken
+	err = sonic.Unmarshal(jBytes, &token)
+	if err != nil || token.VirtualBucket != virtualBucket || token.KeyID != keyID {
+		return nil, ErrInvalidListToken
+	}
+	return &token, nil
+}
+
+func signListToken(payload string) string {
+	hash := hmac.New(sha256.New, []byte(utils.ListTokenSecret))
+	hash.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(hash.Sum(nil))
+}
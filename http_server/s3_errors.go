@@ -0,0 +1,101 @@
+package http_server
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/danthegoodman1/GoAPITemplate/sigv4"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"net/http"
+)
+
+type S3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// s3ErrorCodes are the S3 codes for errors that only have a status, e.g. from echo
+var s3ErrorCodes = map[int]string{
+	http.StatusBadRequest:            "InvalidRequest",
+	http.StatusForbidden:             "AccessDenied",
+	http.StatusNotFound:              "NoSuchKey",
+	http.StatusMethodNotAllowed:      "MethodNotAllowed",
+	http.StatusRequestEntityTooLarge: "EntityTooLarge",
+	http.StatusNotImplemented:        "NotImplemented",
+	http.StatusServiceUnavailable:    "SlowDown",
+}
+
+// S3Error writes an S3 compatible XML error response
+func (c *CustomContext) S3Error(status int, code, message string) error {
+	if c.Request().Method == http.MethodHead {
+		// HEAD responses have no body, so SDKs only see the status
+		return c.NoContent(status)
+	}
+	return c.XML(status, S3Error{
+		Code:      code,
+		Message:   message,
+		Resource:  c.Request().URL.Path,
+		RequestID: c.RequestID,
+	})
+}
+
+// LookupError writes the S3 error for an error from resolving a virtual bucket or its snapshot time
+func (c *CustomContext) LookupError(err error) error {
+	switch {
+	case errors.Is(err, lookup.ErrLookupNotFound), errors.Is(err, lookup.ErrNoPathPrefix):
+		return c.S3Error(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	case errors.Is(err, lookup.ErrAccessDenied):
+		return c.S3Error(http.StatusForbidden, "AccessDenied", "Access Denied")
+	case errors.Is(err, lookup.ErrAsOfTooOld):
+		return c.S3Error(http.StatusForbidden, "AccessDenied", "The as of time is before the earliest allowed for this key")
+	case errors.Is(err, lookup.ErrCircuitOpen), errors.Is(err, lookup.ErrLookupUnavailable):
+		return c.S3Error(http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+	default:
+		return c.InternalError(err, "error resolving virtual bucket")
+	}
+}
+
+// BodyError writes the S3 error for an error from reading an aws-chunked request body
+func (c *CustomContext) BodyError(err error) error {
+	switch {
+	case errors.Is(err, sigv4.ErrChunkSignatureDoesNotMatch):
+		return c.S3Error(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	case errors.Is(err, sigv4.ErrChecksumMismatch):
+		return c.S3Error(http.StatusBadRequest, "BadDigest", "The checksum you specified did not match what we received.")
+	case errors.Is(err, sigv4.ErrDecodedLengthMismatch), errors.Is(err, sigv4.ErrMalformedChunk):
+		return c.S3Error(http.StatusBadRequest, "IncompleteBody", err.Error())
+	default:
+		return c.InternalError(err, "error reading request body")
+	}
+}
+
+// HTTPErrorHandler writes errors returned by handlers and echo (e.g. unknown routes) as S3 errors
+func (srv *HTTPServer) HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	cc, ok := c.(*CustomContext)
+	if !ok {
+		// Errors returned from the middleware chain are handled with echo's context
+		cc = &CustomContext{
+			Context:   c,
+			RequestID: c.Response().Header().Get("x-amz-request-id"),
+		}
+	}
+
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		err = cc.InternalError(err, "unhandled error")
+	} else if code, exists := s3ErrorCodes[httpErr.Code]; exists {
+		err = cc.S3Error(httpErr.Code, code, http.StatusText(httpErr.Code))
+	} else {
+		err = cc.S3Error(http.StatusInternalServerError, "InternalError", cc.internalErrorMessage())
+	}
+	if err != nil {
+		zerolog.Ctx(c.Request().Context()).Error().Err(err).Msg("error writing error response")
+	}
+}
@@ -0,0 +1,56 @@
+package http_server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestS3Errors(t *testing.T) {
+	srv := &HTTPServer{Echo: echo.New()}
+	srv.Echo.HTTPErrorHandler = srv.HTTPErrorHandler
+	srv.Echo.Use(CreateReqContext)
+	srv.Echo.GET("/lookup", func(c echo.Context) error {
+		return c.(*CustomContext).LookupError(fmt.Errorf("error in resolver.Resolve: %w", lookup.ErrLookupNotFound))
+	})
+	srv.Echo.GET("/slow", func(c echo.Context) error {
+		return c.(*CustomContext).LookupError(lookup.ErrCircuitOpen)
+	})
+	srv.Echo.GET("/unavailable", func(c echo.Context) error {
+		return c.(*CustomContext).LookupError(fmt.Errorf("error in resolver.Resolve: %w", lookup.ErrLookupUnavailable))
+	})
+	srv.Echo.GET("/internal", func(c echo.Context) error {
+		return c.(*CustomContext).InternalError(fmt.Errorf("boom"), "test error")
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/lookup", http.StatusNotFound, "NoSuchBucket"},
+		{"/slow", http.StatusServiceUnavailable, "SlowDown"},
+		{"/unavailable", http.StatusServiceUnavailable, "SlowDown"},
+		{"/internal", http.StatusInternalServerError, "InternalError"},
+		// Handled by echo
+		{"/missing", http.StatusNotFound, "NoSuchKey"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		var s3Err S3Error
+		if err := xml.Unmarshal(rec.Body.Bytes(), &s3Err); err != nil {
+			t.Fatalf("%s: bad xml %s: %s", test.path, err, rec.Body.String())
+		}
+		if rec.Code != test.status || s3Err.Code != test.code {
+			t.Fatalf("%s: expected %d %s, got %d %s", test.path, test.status, test.code, rec.Code, s3Err.Code)
+		}
+		if requestID := rec.Header().Get("x-amz-request-id"); requestID == "" || requestID != s3Err.RequestID {
+			t.Fatalf("%s: bad request id header %s for %s", test.path, requestID, s3Err.RequestID)
+		}
+	}
+}
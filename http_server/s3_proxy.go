@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"github.com/danthegoodman1/GoAPITemplate/icedb"
 	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/danthegoodman1/GoAPITemplate/storage"
 	"github.com/danthegoodman1/GoAPITemplate/utils"
 	"github.com/rs/zerolog"
+	"github.com/samber/lo"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -72,58 +75,60 @@ type ListObjectRequest struct {
 func (srv *HTTPServer) ListObjectInterceptor(c *CustomContext) error {
 	var req ListObjectRequest
 	if err := c.Bind(&req); err != nil {
-		return c.InternalError(err, "error binding")
-	}
-
-	var virtualBucketName string
-	if c.IsPathRouting {
-		// vhost routing
-		domainParts := strings.Split(c.Request().Host, ".")
-		virtualBucketName = domainParts[0]
-	} else {
-		// path routing
-		u, err := url.Parse(c.Request().RequestURI)
-		if err != nil {
-			return c.InternalError(err, "error in url.Parse")
-		}
-		pathParts := strings.Split(u.Path, "/")
-		if len(pathParts) == 0 {
-			return c.String(http.StatusNotFound, "not found (invalid path")
-		}
-		virtualBucketName = pathParts[1]
+		return c.S3Error(http.StatusBadRequest, "InvalidArgument", "Invalid list parameters")
 	}
 
 	logger := zerolog.Ctx(c.Request().Context())
 	logger.Debug().Msg("got list request")
 
-	maxKeys := utils.Deref(req.MaxKeys, 1000)
+	// S3 caps max-keys at 1000
+	maxKeys := max(min(utils.Deref(req.MaxKeys, 1000), 1000), 0)
 
-	// realBucketName := ""    // TODO: from lookup
-	logReader, err := icedb.NewIceDBLogReader(c.Request().Context())
+	// Resolve virtual bucket
+	resolvedBucket, err := lookup.ResolveVirtualBucket(c.Request().Context(), c.VirtualBucketName, c.AWSCredentials.KeyID)
 	if err != nil {
-		return fmt.Errorf("error in NewIceDBLogReader: %w", err)
+		return c.LookupError(err)
 	}
 
-	// Resolve virtual bucket
-	resolvedBucket, err := lookup.ResolveVirtualBucket(c.Request().Context(), c.VirtualBucketName, c.AWSCredentials.KeyID)
+	logReader, err := newLogReader(c, resolvedBucket)
 	if err != nil {
-		return c.InternalError(err, "error in lookup.ResolveVirtualBucket")
+		return c.InternalError(err, "error in newLogReader")
 	}
 
-	// Prioritize ContinuationToken which is used if paginating (we force it to be last item), otherwise use StartAfter
-	offset := utils.Deref(req.ContinuationToken, utils.Deref(req.StartAfter, ""))
+	// Prioritize ContinuationToken which is used if paginating, otherwise use StartAfter.
+	// The token pins the snapshot time so all pages are from the same snapshot.
+	dataPrefix := resolvedBucket.Prefix + "/_data/"
+	offset := utils.Deref(req.StartAfter, "")
+	snapshotTimeMS, err := resolvedBucket.SnapshotTimeAsOf(c.AsOfMS)
+	if err != nil {
+		return c.LookupError(err)
+	}
+	if req.ContinuationToken != nil {
+		token, err := decodeListToken(*req.ContinuationToken, c.VirtualBucketName, c.AWSCredentials.KeyID)
+		if err != nil {
+			return c.S3Error(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+		}
+		offset = token.LastKey
+		// Capped like time travel, in case the key's pinned or min time changed since the first page
+		snapshotTimeMS, err = resolvedBucket.SnapshotTimeAsOf(token.TimeMS)
+		if err != nil {
+			return c.LookupError(err)
+		}
+	}
 
 	res := ListBucketResult{
-		XMLName:      xml.Name{},
-		IsTruncated:  false, // let's just serve all of them
-		Name:         virtualBucketName,
-		MaxKeys:      maxKeys,
-		EncodingType: "url",
-		KeyCount:     maxKeys,
+		XMLName:           xml.Name{},
+		Name:              c.RequestedBucketName,
+		MaxKeys:           maxKeys,
+		EncodingType:      "url",
+		ContinuationToken: utils.Deref(req.ContinuationToken, ""),
+		StartAfter:        utils.Deref(req.StartAfter, ""),
+		Prefix:            utils.Deref(req.Prefix, ""),
+		Delimiter:         utils.Deref(req.Delimiter, ""),
 	}
 
-	aliveFiles, err := logReader.ReadState(c.Request().Context(), resolvedBucket.Prefix, offset, utils.Deref(resolvedBucket.TimeMS, time.Now().UnixMilli()), int64(utils.Deref(req.MaxKeys, 1000)))
-	if errors.Is(err, icedb.ErrNoLogFiles) {
+	snapshot, err := logReader.ReadState(c.Request().Context(), resolvedBucket.Prefix, snapshotTimeMS)
+	if errors.Is(err, icedb.ErrNoLogFiles) || errors.Is(err, icedb.ErrNoAliveFiles) {
 		// Just return no items
 		return c.XML(http.StatusOK, res)
 	}
@@ -131,16 +136,46 @@ func (srv *HTTPServer) ListObjectInterceptor(c *CustomContext) error {
 		return c.InternalError(err, "error in ReadState")
 	}
 
+	page := snapshot.Page(icedb.PageOptions{
+		Prefix:     dataPrefix + res.Prefix,
+		Delimiter:  res.Delimiter,
+		StartAfter: lo.Ternary(offset == "", "", dataPrefix+offset),
+		MaxItems:   int64(maxKeys),
+	})
+
 	var contents []Content
-	for _, af := range aliveFiles.AliveFiles {
+	for _, af := range page.Files {
 		contents = append(contents, Content{
-			Key:          strings.ReplaceAll(af.Path, resolvedBucket.Prefix+"/_data/", ""), // drop the prefix
+			Key:          strings.TrimPrefix(af.Path, dataPrefix), // drop the prefix
 			Size:         af.ByteLength,
+			ETag:         af.ETag(),
+			LastModified: af.LastModified(),
 			StorageClass: "STANDARD",
 		})
 	}
+	var commonPrefixes []CommonPrefix
+	for _, cp := range page.CommonPrefixes {
+		commonPrefixes = append(commonPrefixes, CommonPrefix{
+			Prefix: strings.TrimPrefix(cp, dataPrefix),
+		})
+	}
 
 	res.Contents = contents
+	res.CommonPrefixes = commonPrefixes
+	res.KeyCount = len(contents) + len(commonPrefixes)
+	// With max-keys=0 there is no key to continue from, so it is not truncated (or clients would loop forever)
+	res.IsTruncated = page.Truncated && page.LastKey != ""
+	if res.IsTruncated {
+		res.NextContinuationToken, err = encodeListToken(listToken{
+			VirtualBucket: c.VirtualBucketName,
+			KeyID:         c.AWSCredentials.KeyID,
+			LastKey:       strings.TrimPrefix(page.LastKey, dataPrefix),
+			TimeMS:        snapshotTimeMS,
+		})
+		if err != nil {
+			return c.InternalError(err, "error in encodeListToken")
+		}
+	}
 
 	return c.XML(http.StatusOK, res)
 }
@@ -162,17 +197,30 @@ func (srv *HTTPServer) CheckListOrGetObject(c *CustomContext) error {
 		}
 		pathParts := strings.Split(u.Path, "/")
 
-		if len(pathParts) == 3 && pathParts[2] == "" {
-			// This is a `/bucket/` request, ListObject(V2)
+		if len(pathParts) == 2 || (len(pathParts) == 3 && pathParts[2] == "") {
+			// This is a `/bucket` or `/bucket/` request, ListObject(V2)
 			logger.Debug().Msg("request is list")
 			return srv.ListObjectInterceptor(c)
 		}
 		// Otherwise we are `/bucket/**`, get object
 		logger.Debug().Msg("request is get")
-		return srv.ProxyS3Request(c)
+		return srv.GetObject(c)
 	} else {
-		// vhost routing
-		return srv.ListObjectInterceptor(c)
+		// vhost routing, `/` is routed to list directly, so this is a get object
+		logger.Debug().Msg("request is get")
+		return srv.GetObject(c)
+	}
+}
+
+// GetObject serves the virtual objects that the proxy synthesizes, and proxies everything else
+func (srv *HTTPServer) GetObject(c *CustomContext) error {
+	switch c.ObjectKey() {
+	case schemaObjectKey, schemaAPIKey:
+		return srv.GetSchema(c)
+	case diffAPIKey:
+		return srv.GetDiff(c)
+	default:
+		return srv.ProxyS3Request(c)
 	}
 }
 
@@ -182,57 +230,122 @@ func (srv *HTTPServer) ProxyS3Request(c *CustomContext) error {
 	// Resolve virtual bucket
 	resolvedBucket, err := lookup.ResolveVirtualBucket(c.Request().Context(), c.VirtualBucketName, c.AWSCredentials.KeyID)
 	if err != nil {
-		return c.InternalError(err, "error in lookup.ResolveVirtualBucket")
+		return c.LookupError(err)
 	}
 
-	droppedVirtualBucket := strings.ReplaceAll(c.Request().RequestURI, c.VirtualBucketName, "")
-	prefixWithData := resolvedBucket.Prefix + "/_data"
+	target, err := resolvedBucket.StorageTarget()
+	if err != nil {
+		return c.InternalError(err, "error in StorageTarget")
+	}
+	c.RealBucketName = target.Bucket
 
-	newPath := prefixWithData + droppedVirtualBucket
-	if c.IsPathRouting {
-		// Need to deal with the bucket `/bucket/...` needs to be `/bucket/prefix/...
-		// If the client is using path routing, we need to use path routing
-		pathParts := strings.Split(droppedVirtualBucket, "/")
-		newPathParts := []string{prefixWithData}
-		if utils.S3UsePath {
-			// if we are path routing, then we need to prepend the bucket
-			newPathParts = []string{utils.S3Bucket, prefixWithData}
-		}
-		newPathParts = append(newPathParts, pathParts[2:]...)
-		newPath = "/" + strings.Join(newPathParts, "/")
+	// Only serve files that are alive in the snapshot, so tombstoned files, files newer than the
+	// snapshot, and anything else under the prefix (e.g. `_log`) can't be read
+	objectPath := resolvedBucket.Prefix + "/_data/" + c.ObjectKey()
+	snapshotTimeMS, err := resolvedBucket.SnapshotTimeAsOf(c.AsOfMS)
+	if err != nil {
+		return c.LookupError(err)
+	}
+	snapshot, err := readSnapshot(c, resolvedBucket, snapshotTimeMS)
+	if errors.Is(err, icedb.ErrNoLogFiles) || errors.Is(err, icedb.ErrNoAliveFiles) {
+		return c.S3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	if err != nil {
+		return c.InternalError(err, "error in readSnapshot")
+	}
+	marker, alive := snapshot.AliveFile(objectPath)
+	if !alive {
+		return c.S3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
 	}
 
-	finalURL := utils.S3UrlWithSubdomain + newPath
+	// The ETag and Last-Modified come from the log so they match the listing
+	etag, lastModified := marker.ETag(), marker.LastModified()
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	if status := checkConditions(c.Request().Header, etag, lastModified); status != 0 {
+		if status == http.StatusPreconditionFailed {
+			return c.S3Error(status, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		}
+		return c.NoContent(status)
+	}
 
+	store, err := storage.NewObjectStore(c.Request().Context(), target)
+	if err != nil {
+		return c.InternalError(err, "error in storage.NewObjectStore")
+	}
 	logger.UpdateContext(func(ctx zerolog.Context) zerolog.Context {
-		return ctx.Bool("proxied", true).Str("finalURL", finalURL)
+		return ctx.Bool("proxied", true).Str("objectPath", objectPath)
 	})
-	logger.Debug().Str("authHeader", c.Request().Header.Get("Authorization")).Msg("proxying request")
 
-	req, err := http.NewRequestWithContext(c.Request().Context(), c.Request().Method, finalURL, nil)
+	c.Response().Header().Set("Accept-Ranges", "bytes")
+
+	if c.Request().Method == http.MethodHead {
+		info, err := store.Head(c.Request().Context(), objectPath)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return c.S3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		}
+		if err != nil {
+			return c.InternalError(err, "error in store.Head")
+		}
+		c.Response().Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		c.Response().Header().Set("Content-Type", lo.Ternary(info.ContentType == "", "application/octet-stream", info.ContentType))
+		return c.NoContent(http.StatusOK)
+	}
+
+	// A Range is only honored if an If-Range still matches
+	var obj *storage.Object
+	byteRange := storage.ParseRange(c.Request().Header.Get("Range"))
+	if byteRange != nil && ifRangeMatches(c.Request().Header, etag, lastModified) {
+		obj, err = store.GetRange(c.Request().Context(), objectPath, *byteRange)
+	} else {
+		obj, err = store.Get(c.Request().Context(), objectPath)
+	}
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return c.S3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	if errors.Is(err, storage.ErrInvalidRange) {
+		return c.S3Error(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+	}
 	if err != nil {
-		return c.InternalError(err, "error making new request for proxying")
+		return c.InternalError(err, "error getting object from store")
 	}
+	defer obj.Body.Close()
 
-	// Copy headers
-	headers := c.Request().Header.Clone()
-	// If we have an access key, throw it away, as it's partially based on the host
-	headers.Del("Authorization")
-	req.Header = headers
+	status := http.StatusOK
+	if obj.ContentRange != "" {
+		status = http.StatusPartialContent
+		c.Response().Header().Set("Content-Range", obj.ContentRange)
+	}
+	c.Response().Header().Set("Content-Length", strconv.FormatInt(obj.ContentLength, 10))
+	return c.Stream(status, lo.Ternary(obj.ContentType == "", "application/octet-stream", obj.ContentType), obj.Body)
+}
 
-	res, err := http.DefaultClient.Do(req)
+// newLogReader creates a log reader for the virtual bucket's storage target and schema policy
+func newLogReader(c *CustomContext, resolvedBucket *lookup.VirtualBucketResolveRes) (*icedb.IceDBLogReader, error) {
+	schemaPolicy, err := icedb.ParseSchemaPolicy(resolvedBucket.SchemaPolicyName())
 	if err != nil {
-		return c.InternalError(err, "error doing proxy request")
+		return nil, fmt.Errorf("error in ParseSchemaPolicy: %w", err)
 	}
-	defer res.Body.Close()
-
-	// Copy headers
-	for name, headers := range res.Header {
-		// Iterate all headers with one name (e.g. Content-Type)
-		for _, hdr := range headers {
-			c.Response().Header().Set(name, hdr)
-		}
+	target, err := resolvedBucket.StorageTarget()
+	if err != nil {
+		return nil, fmt.Errorf("error in StorageTarget: %w", err)
+	}
+	logReader, err := icedb.NewIceDBLogReader(c.Request().Context(), target, schemaPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("error in NewIceDBLogReader: %w", err)
 	}
+	return logReader, nil
+}
 
-	return c.Stream(res.StatusCode, res.Header.Get("content-type"), res.Body)
+// readSnapshot reads the IceDB snapshot of the virtual bucket at snapshotTimeMS
+func readSnapshot(c *CustomContext, resolvedBucket *lookup.VirtualBucketResolveRes, snapshotTimeMS int64) (*icedb.LogSnapshot, error) {
+	logReader, err := newLogReader(c, resolvedBucket)
+	if err != nil {
+		return nil, fmt.Errorf("error in newLogReader: %w", err)
+	}
+	snapshot, err := logReader.ReadState(c.Request().Context(), resolvedBucket.Prefix, snapshotTimeMS)
+	if err != nil {
+		return nil, fmt.Errorf("error in ReadState: %w", err)
+	}
+	return snapshot, nil
 }
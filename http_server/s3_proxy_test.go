@@ -1,10 +1,69 @@
 package http_server
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"github.com/danthegoodman1/GoAPITemplate/sigv4"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/uber-go/tally/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+// useLocalTestTable resolves every virtual bucket to a local table with three data files
+func useLocalTestTable(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	logDir := filepath.Join(root, "bucket", "tenant", "_log")
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{`{"v":1,"t":1700000000000,"sch":1,"f":2}`, `{"user_id":"VARCHAR"}`}
+	for _, name := range []string{"a", "b", "c"} {
+		lines = append(lines, fmt.Sprintf(`{"p":"tenant/_data/%s.parquet","b":100,"t":1700000000000}`, name))
+	}
+	err := os.WriteFile(filepath.Join(logDir, "1700000000000_a.jsonl"), []byte(strings.Join(lines, "\n")), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix, endpoint, bucket := utils.DevLookupPrefix, utils.S3Url, utils.S3Bucket
+	t.Cleanup(func() {
+		utils.DevLookupPrefix, utils.S3Url, utils.S3Bucket = prefix, endpoint, bucket
+	})
+	utils.DevLookupPrefix, utils.S3Url, utils.S3Bucket = "tenant", "file://"+root, "bucket"
+	if err = lookup.InitResolver(context.Background(), tally.NoopScope); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTestHandler calls the handler as keyID, as if the request was verified
+func serveTestHandler(t *testing.T, handler func(*CustomContext) error, target, keyID string) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	cc := &CustomContext{
+		Context:             e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec),
+		VirtualBucketName:   "bucket",
+		RequestedBucketName: "bucket",
+		AWSCredentials:      sigv4.Credential{KeyID: keyID},
+		IsPathRouting:       true,
+	}
+	if err := handler(cc); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
 func TestListObjectResult(t *testing.T) {
 	res := ListBucketResult{
 		XMLName:     xml.Name{},
@@ -29,3 +88,100 @@ func TestListObjectResult(t *testing.T) {
 	}
 	t.Log(string(resb))
 }
+
+func TestListToken(t *testing.T) {
+	token, err := encodeListToken(listToken{
+		VirtualBucket: "bucket",
+		KeyID:         "AKID",
+		LastKey:       "some/sample.parquet",
+		TimeMS:        1700000000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeListToken(token, "bucket", "AKID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.LastKey != "some/sample.parquet" || decoded.TimeMS != 1700000000000 {
+		t.Fatalf("bad decoded token %+v", decoded)
+	}
+
+	if _, err = decodeListToken(token, "otherbucket", "AKID"); err == nil {
+		t.Fatal("token was valid for another bucket")
+	}
+	if _, err = decodeListToken(token, "bucket", "OTHERKEY"); err == nil {
+		t.Fatal("token was valid for another key")
+	}
+	if _, err = decodeListToken("x"+token, "bucket", "AKID"); err == nil {
+		t.Fatal("tampered token was valid")
+	}
+}
+
+func TestAsOf(t *testing.T) {
+	asOfMS, err := parseAsOf("1700000000000")
+	if err != nil || asOfMS != 1700000000000 {
+		t.Fatalf("bad ms as of %d %v", asOfMS, err)
+	}
+	asOfMS, err = parseAsOf("2023-11-14T22:13:20Z")
+	if err != nil || asOfMS != 1700000000000 {
+		t.Fatalf("bad RFC3339 as of %d %v", asOfMS, err)
+	}
+	if _, err = parseAsOf("yesterday"); err == nil {
+		t.Fatal("parsed bad as of")
+	}
+
+	res := lookup.VirtualBucketResolveRes{
+		TimeMS:    utils.Ptr(int64(1700000000000)),
+		MinTimeMS: utils.Ptr(int64(1600000000000)),
+	}
+	snapshotTimeMS, err := res.SnapshotTimeAsOf(1650000000000)
+	if err != nil || snapshotTimeMS != 1650000000000 {
+		t.Fatalf("bad snapshot time %d %v", snapshotTimeMS, err)
+	}
+	// Capped at the pinned time
+	snapshotTimeMS, err = res.SnapshotTimeAsOf(1800000000000)
+	if err != nil || snapshotTimeMS != 1700000000000 {
+		t.Fatalf("bad capped snapshot time %d %v", snapshotTimeMS, err)
+	}
+	if _, err = res.SnapshotTimeAsOf(1500000000000); !errors.Is(err, lookup.ErrAsOfTooOld) {
+		t.Fatalf("expected too old, got %v", err)
+	}
+}
+
+func TestListObjectsPagination(t *testing.T) {
+	useLocalTestTable(t)
+	srv := &HTTPServer{}
+	list := func(query url.Values, keyID string) (int, ListBucketResult) {
+		rec := serveTestHandler(t, srv.ListObjectInterceptor, "/bucket/?"+query.Encode(), keyID)
+		var res ListBucketResult
+		if rec.Code == http.StatusOK {
+			if err := xml.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, res
+	}
+
+	// Nothing to continue from, so clients must not keep paginating
+	_, res := list(url.Values{"list-type": {"2"}, "max-keys": {"0"}}, "AKID")
+	if res.IsTruncated || res.NextContinuationToken != "" || res.KeyCount != 0 {
+		t.Fatalf("bad max-keys=0 result %+v", res)
+	}
+
+	_, res = list(url.Values{"list-type": {"2"}, "max-keys": {"2"}}, "AKID")
+	if !res.IsTruncated || res.NextContinuationToken == "" || res.KeyCount != 2 {
+		t.Fatalf("bad first page %+v", res)
+	}
+	token := res.NextContinuationToken
+	_, res = list(url.Values{"list-type": {"2"}, "continuation-token": {token}}, "AKID")
+	if res.IsTruncated || len(res.Contents) != 1 || res.Contents[0].Key != "c.parquet" {
+		t.Fatalf("bad second page %+v", res)
+	}
+
+	// Another key can't replay the token
+	if code, _ := list(url.Values{"list-type": {"2"}, "continuation-token": {token}}, "OTHERKEY"); code != http.StatusBadRequest {
+		t.Fatalf("token replayed by another key %d", code)
+	}
+}
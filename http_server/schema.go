@@ -0,0 +1,64 @@
+package http_server
+
+import (
+	"errors"
+	"github.com/danthegoodman1/GoAPITemplate/icedb"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"net/http"
+)
+
+const (
+	// schemaObjectKey is a virtual object in every bucket with the merged schema of the snapshot
+	schemaObjectKey = "_schema.json"
+	// schemaAPIKey renders the merged schema as JSON, or as DDL with ?format=clickhouse|duckdb
+	schemaAPIKey = "_icedb/schema"
+)
+
+type SchemaResponse struct {
+	TimeMS  int64
+	Columns []icedb.NamedColumn
+}
+
+func (srv *HTTPServer) GetSchema(c *CustomContext) error {
+	resolvedBucket, err := lookup.ResolveVirtualBucket(c.Request().Context(), c.VirtualBucketName, c.AWSCredentials.KeyID)
+	if err != nil {
+		return c.LookupError(err)
+	}
+
+	snapshotTimeMS, err := resolvedBucket.SnapshotTimeAsOf(c.AsOfMS)
+	if err != nil {
+		return c.LookupError(err)
+	}
+	snapshot, err := readSnapshot(c, resolvedBucket, snapshotTimeMS)
+	if errors.Is(err, icedb.ErrNoLogFiles) || errors.Is(err, icedb.ErrNoAliveFiles) {
+		return c.S3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	if err != nil {
+		return c.InternalError(err, "error in readSnapshot")
+	}
+
+	if c.ObjectKey() == schemaObjectKey {
+		// Same format as the schema line of IceDB log files
+		return c.JSON(http.StatusOK, snapshot.Schema)
+	}
+
+	table := c.QueryParam("table")
+	if table == "" {
+		table = c.VirtualBucketName
+	}
+	switch c.QueryParam("format") {
+	case "", "json":
+		return c.JSON(http.StatusOK, SchemaResponse{
+			TimeMS:  snapshotTimeMS,
+			Columns: snapshot.OrderedColumns(),
+		})
+	case "clickhouse":
+		// Point at the proxy, so ClickHouse lists and reads the same snapshot
+		return c.String(http.StatusOK, snapshot.ClickHouseDDL(table, c.bucketURL()+"/**.parquet", c.AWSCredentials.KeyID))
+	case "duckdb":
+		// DuckDB must be configured with the proxy as its S3 endpoint
+		return c.String(http.StatusOK, snapshot.DuckDBDDL(table, "s3://"+c.RequestedBucketName+"/**/*.parquet"))
+	default:
+		return c.S3Error(http.StatusBadRequest, "InvalidArgument", "format must be json, clickhouse, or duckdb")
+	}
+}
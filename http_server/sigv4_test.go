@@ -0,0 +1,148 @@
+package http_server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/danthegoodman1/GoAPITemplate/auth"
+	"github.com/danthegoodman1/GoAPITemplate/sigv4"
+	"github.com/labstack/echo/v4"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// s3Signer signs like the S3 client, which doesn't escape the already escaped path again
+func s3Signer() *v4.Signer {
+	return v4.NewSigner(func(options *v4.SignerOptions) {
+		options.DisableURIPathEscaping = true
+	})
+}
+
+func newSigV4TestServer(t *testing.T) *HTTPServer {
+	t.Helper()
+	store, err := auth.NewEnvCredentialStore("AKID:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &HTTPServer{Echo: echo.New(), CredentialStore: store}
+	srv.Echo.HTTPErrorHandler = srv.HTTPErrorHandler
+	srv.Echo.Use(CreateReqContext)
+	srv.Echo.GET("/*", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.(*CustomContext).AWSCredentials.KeyID)
+	}, srv.verifyAWSRequest)
+	srv.Echo.PUT("/*", func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.(*CustomContext).BodyError(err)
+		}
+		return c.Blob(http.StatusOK, "text/plain", body)
+	}, srv.verifyAWSRequest)
+	return srv
+}
+
+func TestPresignedURL(t *testing.T) {
+	srv := newSigV4TestServer(t)
+	creds := aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}
+
+	presign := func(signedAt time.Time, expires string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/bucket/some%20file.parquet?x-id=GetObject&X-Amz-Expires="+expires, nil)
+		signedURL, _, err := s3Signer().PresignHTTP(context.Background(), creds, req, "UNSIGNED-PAYLOAD", "s3", "us-east-1", signedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signedURL
+	}
+	do := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	signedURL := presign(time.Now(), "300")
+	if rec := do(signedURL); rec.Code != http.StatusOK || rec.Body.String() != "AKID" {
+		t.Fatalf("presigned url failed %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Tampering with the query breaks the signature
+	u, _ := url.Parse(signedURL)
+	query := u.Query()
+	query.Set("x-id", "PutObject")
+	u.RawQuery = query.Encode()
+	if rec := do(u.String()); rec.Code != http.StatusForbidden {
+		t.Fatalf("tampered url was allowed %d", rec.Code)
+	}
+
+	if rec := do(presign(time.Now().Add(-time.Hour), "300")); rec.Code != http.StatusForbidden {
+		t.Fatalf("expired url was allowed %d", rec.Code)
+	}
+	if rec := do(presign(time.Now(), "9999999")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("url with too long expiry was allowed %d", rec.Code)
+	}
+	if rec := do("http://localhost:8080/bucket/file.parquet?X-Amz-Signature=abc"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid presign was allowed %d", rec.Code)
+	}
+}
+
+func TestSignedHeader(t *testing.T) {
+	srv := newSigV4TestServer(t)
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/bucket/?list-type=2&prefix=a%20b", nil)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	err := s3Signer().SignHTTP(context.Background(), aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, req, "UNSIGNED-PAYLOAD", "s3", "us-east-1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed request failed %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignedHeaderSkew(t *testing.T) {
+	srv := newSigV4TestServer(t)
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/bucket/file.parquet", nil)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	// Signed for another region, which is taken from the credential scope
+	err := s3Signer().SignHTTP(context.Background(), aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, req, "UNSIGNED-PAYLOAD", "s3", "eu-central-1", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "RequestTimeTooSkewed") {
+		t.Fatalf("skewed request was allowed %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStreamingTrailer(t *testing.T) {
+	srv := newSigV4TestServer(t)
+	do := func(checksum uint32) *httptest.ResponseRecorder {
+		body := "b\r\nhello world\r\n0\r\nx-amz-checksum-crc32:" + base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, checksum)) + "\r\n\r\n"
+		req := httptest.NewRequest(http.MethodPut, "http://localhost:8080/bucket/file.txt", strings.NewReader(body))
+		req.Header.Set("x-amz-content-sha256", sigv4.StreamingUnsignedPayloadTrailer)
+		req.Header.Set("x-amz-trailer", "x-amz-checksum-crc32")
+		req.Header.Set("x-amz-decoded-content-length", "11")
+		err := s3Signer().SignHTTP(context.Background(), aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, req, sigv4.StreamingUnsignedPayloadTrailer, "s3", "us-east-1", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(crc32.ChecksumIEEE([]byte("hello world"))); rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("streaming upload failed %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(0); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "BadDigest") {
+		t.Fatalf("bad checksum was allowed %d %s", rec.Code, rec.Body.String())
+	}
+}
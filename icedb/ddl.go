@@ -0,0 +1,136 @@
+package icedb
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// typePairs map DuckDB types to ClickHouse types, the first pair for a ClickHouse type is used for the reverse
+var typePairs = [][2]string{
+	{"VARCHAR", "String"},
+	{"BIGINT", "Int64"},
+	{"INTEGER", "Int32"},
+	{"SMALLINT", "Int16"},
+	{"TINYINT", "Int8"},
+	{"HUGEINT", "Int128"},
+	{"UBIGINT", "UInt64"},
+	{"UINTEGER", "UInt32"},
+	{"USMALLINT", "UInt16"},
+	{"UTINYINT", "UInt8"},
+	{"DOUBLE", "Float64"},
+	{"FLOAT", "Float32"},
+	{"REAL", "Float32"},
+	{"BOOLEAN", "Bool"},
+	{"DATE", "Date32"},
+	{"TIMESTAMP", "DateTime64(6)"},
+	{"UUID", "UUID"},
+	{"BLOB", "String"},
+	{"JSON", "String"},
+}
+
+type (
+	// NamedColumn is a column with its name, for ordered output
+	NamedColumn struct {
+		Name string
+		Column
+	}
+)
+
+// OrderedColumns returns the columns in the order they were added, then by name
+func (s *LogSnapshot) OrderedColumns() []NamedColumn {
+	cols := make([]NamedColumn, 0, len(s.Columns))
+	for name, col := range s.Columns {
+		cols = append(cols, NamedColumn{Name: name, Column: col})
+	}
+	slices.SortFunc(cols, func(a, b NamedColumn) int {
+		if a.AddedMS != b.AddedMS {
+			return cmp.Compare(a.AddedMS, b.AddedMS)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return cols
+}
+
+// ClickHouseType converts a DuckDB type to ClickHouse, unknown types are returned as is
+func ClickHouseType(colType string) string {
+	for _, pair := range typePairs {
+		if pair[1] == colType {
+			return colType
+		}
+	}
+	for _, pair := range typePairs {
+		if pair[0] == strings.ToUpper(colType) {
+			return pair[1]
+		}
+	}
+	return colType
+}
+
+// DuckDBType converts a ClickHouse type to DuckDB, unknown types are returned as is
+func DuckDBType(colType string) string {
+	for _, pair := range typePairs {
+		if pair[1] == colType {
+			return pair[0]
+		}
+	}
+	return colType
+}
+
+// secretPlaceholder stands in for the secret access key in rendered DDL, which is never known to the proxy
+const secretPlaceholder = "<secret_access_key>"
+
+// ClickHouseDDL renders a CREATE TABLE over the parquet files at url with the S3 table engine. The proxy requires
+// signed requests, so the engine is given keyID and a placeholder for its secret to fill in.
+func (s *LogSnapshot) ClickHouseDDL(table, url, keyID string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE TABLE %s\n(\n", clickHouseIdent(table))
+	for i, col := range s.OrderedColumns() {
+		// Rows don't need to have every column
+		colType := ClickHouseType(col.Type)
+		if !strings.HasPrefix(colType, "Nullable(") {
+			colType = "Nullable(" + colType + ")"
+		}
+		fmt.Fprintf(&sb, "    %s %s", clickHouseIdent(col.Name), colType)
+		if i < len(s.Columns)-1 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, ")\nENGINE = S3(%s, %s, %s, 'Parquet')\n", clickHouseString(url), clickHouseString(keyID), clickHouseString(secretPlaceholder))
+	return sb.String()
+}
+
+// DuckDBDDL renders a CREATE VIEW over the parquet files at url with read_parquet, casting each column to its type
+func (s *LogSnapshot) DuckDBDDL(view, url string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE VIEW %s AS\nSELECT\n", duckDBIdent(view))
+	for i, col := range s.OrderedColumns() {
+		name := duckDBIdent(col.Name)
+		fmt.Fprintf(&sb, "    CAST(%s AS %s) AS %s", name, DuckDBType(col.Type), name)
+		if i < len(s.Columns)-1 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n")
+	}
+	// Files written before a column was added won't have it
+	fmt.Fprintf(&sb, "FROM read_parquet(%s, union_by_name = true);\n", duckDBString(url))
+	return sb.String()
+}
+
+func clickHouseIdent(ident string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(ident) + "`"
+}
+
+func duckDBIdent(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func clickHouseString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func duckDBString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,33 @@
+package icedb
+
+import "testing"
+
+func TestDDL(t *testing.T) {
+	snap := LogSnapshot{
+		Columns: map[string]Column{
+			"ts":      {Type: "BIGINT", AddedMS: 1000},
+			"user_id": {Type: "VARCHAR", AddedMS: 1000},
+			"it's":    {Type: "Float32", AddedMS: 2000},
+		},
+	}
+
+	ch := snap.ClickHouseDDL("events", "http://localhost:8080/bucket/**.parquet", "AKID")
+	expected := "CREATE TABLE `events`\n(\n" +
+		"    `ts` Nullable(Int64),\n" +
+		"    `user_id` Nullable(String),\n" +
+		"    `it's` Nullable(Float32)\n" +
+		")\nENGINE = S3('http://localhost:8080/bucket/**.parquet', 'AKID', '<secret_access_key>', 'Parquet')\n"
+	if ch != expected {
+		t.Fatalf("bad clickhouse ddl:\n%s", ch)
+	}
+
+	duck := snap.DuckDBDDL("events", "s3://bucket/**/*.parquet")
+	expected = "CREATE VIEW \"events\" AS\nSELECT\n" +
+		"    CAST(\"ts\" AS BIGINT) AS \"ts\",\n" +
+		"    CAST(\"user_id\" AS VARCHAR) AS \"user_id\",\n" +
+		"    CAST(\"it's\" AS FLOAT) AS \"it's\"\n" +
+		"FROM read_parquet('s3://bucket/**/*.parquet', union_by_name = true);\n"
+	if duck != expected {
+		t.Fatalf("bad duckdb ddl:\n%s", duck)
+	}
+}
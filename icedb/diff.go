@@ -0,0 +1,78 @@
+package icedb
+
+import (
+	"slices"
+	"strings"
+)
+
+type (
+	DiffResult struct {
+		// Files alive in the to snapshot but not the from snapshot
+		Added []FileMarker
+		// Files alive in the from snapshot but not the to snapshot
+		Removed   []FileMarker
+		Truncated bool
+		// The last file path of the page, to continue from
+		LastKey string
+	}
+)
+
+// Diff compares the alive files of two snapshots by path, returning up to maxItems added and removed files
+// after startAfter. A nil snapshot has no alive files.
+func Diff(from, to *LogSnapshot, startAfter string, maxItems int64) DiffResult {
+	res := DiffResult{
+		Added:   []FileMarker{},
+		Removed: []FileMarker{},
+	}
+	fromFiles, toFiles := from.aliveFilesAfter(startAfter), to.aliveFilesAfter(startAfter)
+
+	var count int64
+	i, j := 0, 0
+	for i < len(fromFiles) || j < len(toFiles) {
+		var cmp int
+		switch {
+		case i == len(fromFiles):
+			cmp = 1
+		case j == len(toFiles):
+			cmp = -1
+		default:
+			cmp = strings.Compare(fromFiles[i].Path, toFiles[j].Path)
+		}
+		if cmp == 0 {
+			// Alive in both
+			i++
+			j++
+			continue
+		}
+
+		if count == maxItems {
+			res.Truncated = true
+			break
+		}
+		count++
+
+		if cmp < 0 {
+			res.Removed = append(res.Removed, fromFiles[i])
+			res.LastKey = fromFiles[i].Path
+			i++
+		} else {
+			res.Added = append(res.Added, toFiles[j])
+			res.LastKey = toFiles[j].Path
+			j++
+		}
+	}
+	return res
+}
+
+func (s *LogSnapshot) aliveFilesAfter(startAfter string) []FileMarker {
+	if s == nil {
+		return nil
+	}
+	ind, found := slices.BinarySearchFunc(s.AliveFiles, startAfter, func(marker FileMarker, target string) int {
+		return strings.Compare(marker.Path, target)
+	})
+	if found {
+		ind++
+	}
+	return s.AliveFiles[ind:]
+}
@@ -0,0 +1,27 @@
+package icedb
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	from := &LogSnapshot{
+		AliveFiles: []FileMarker{{Path: "a"}, {Path: "b"}, {Path: "d"}},
+	}
+	to := &LogSnapshot{
+		AliveFiles: []FileMarker{{Path: "a"}, {Path: "c"}, {Path: "d"}, {Path: "e"}},
+	}
+
+	res := Diff(from, to, "", 2)
+	if len(res.Removed) != 1 || res.Removed[0].Path != "b" || len(res.Added) != 1 || res.Added[0].Path != "c" || !res.Truncated {
+		t.Fatalf("bad first page %+v", res)
+	}
+	res = Diff(from, to, res.LastKey, 2)
+	if len(res.Removed) != 0 || len(res.Added) != 1 || res.Added[0].Path != "e" || res.Truncated {
+		t.Fatalf("bad second page %+v", res)
+	}
+
+	// Before the table existed, everything is added
+	res = Diff(nil, to, "", 10)
+	if len(res.Added) != 4 || len(res.Removed) != 0 {
+		t.Fatalf("bad diff from nil %+v", res)
+	}
+}
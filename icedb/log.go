@@ -2,52 +2,84 @@ package icedb
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/storage"
 	"github.com/danthegoodman1/GoAPITemplate/utils"
 	"github.com/rs/zerolog"
 	"io"
+	"maps"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	ErrNoLogFiles          = errors.New("no log files found in s3")
-	ErrNoAliveFiles        = errors.New("no alive files")
-	ErrColumnTypeCollision = errors.New("column type collision")
+	ErrNoLogFiles            = errors.New("no log files found in s3")
+	ErrNoAliveFiles          = errors.New("no alive files")
+	ErrColumnTypeCollision   = errors.New("column type collision")
+	ErrInvalidLogFile        = errors.New("invalid log file")
+	ErrUnsupportedLogVersion = errors.New("unsupported log version")
 )
 
+// SupportedLogVersion is the IceDB log format version that can be read
+const SupportedLogVersion = 1
+
 type (
 	IceDBLogReader struct {
-		s3Client *s3.Client
+		store storage.ObjectStore
+		// Identifies the store in the state cache
+		storeID      string
+		schemaPolicy SchemaPolicy
 	}
 )
 
-func NewIceDBLogReader(ctx context.Context) (*IceDBLogReader, error) {
-	logReader := &IceDBLogReader{}
-	s3Creds := credentials.NewStaticCredentialsProvider(utils.AWSKeyID, utils.AWSSecretKey, "")
-	s3Cfg, err := config.LoadDefaultConfig(ctx, config.WithCredentialsProvider(s3Creds), config.WithRegion(utils.AWSRegion))
+// NewIceDBLogReader is cheap to create per request, as S3 targets use the shared S3 client for the target's endpoint
+func NewIceDBLogReader(ctx context.Context, target storage.Target, schemaPolicy SchemaPolicy) (*IceDBLogReader, error) {
+	store, err := storage.NewObjectStore(ctx, target)
 	if err != nil {
-		return nil, fmt.Errorf("error in config.LoadDefaultConfig: %w", err)
+		return nil, fmt.Errorf("error in storage.NewObjectStore: %w", err)
+	}
+	return NewIceDBLogReaderFromStore(store, target.ID(), schemaPolicy), nil
+}
+
+// NewIceDBLogReaderFromStore reads the log from any store, storeID must be unique to the store for caching
+func NewIceDBLogReaderFromStore(store storage.ObjectStore, storeID string, schemaPolicy SchemaPolicy) *IceDBLogReader {
+	return &IceDBLogReader{
+		store:        store,
+		storeID:      storeID,
+		schemaPolicy: schemaPolicy,
 	}
-	logReader.s3Client = s3.NewFromConfig(s3Cfg, func(options *s3.Options) {
-		options.BaseEndpoint = utils.S3UrlPtr
-		options.UsePathStyle = utils.S3UsePath
-	})
-	return logReader, nil
 }
 
 type (
 	LogSnapshot struct {
 		AliveFiles []FileMarker
 		Schema     Schema
+		// The merged schema with when each column was added and changed
+		Columns map[string]Column
+	}
+
+	LogFile struct {
+		Meta        LogMeta
+		Schema      Schema
+		FileMarkers []FileMarker
+		// The log files that were merged into this one, which don't change the alive files
+		Tombstones []Tombstone
+	}
+
+	// foldedState is the result of applying log files in order, up to lastKey
+	foldedState struct {
+		aliveFiles   map[string]FileMarker
+		columns      map[string]Column
+		schemaPolicy SchemaPolicy
+		lastKey      string
+		lastTS       int64
 	}
 
 	LogMeta struct {
@@ -71,152 +103,400 @@ type (
 		TimestampMS int    `json:"t"`
 		Tombstone   *int   `json:"tmb,omitempty"`
 	}
+
+	PageOptions struct {
+		Prefix, Delimiter, StartAfter string
+		MaxItems                      int64
+	}
+
+	PageResult struct {
+		Files          []FileMarker
+		CommonPrefixes []string
+		Truncated      bool
+		// The last file path or common prefix of the page, to continue from
+		LastKey string
+	}
 )
 
-// Offset is with the path prefix
-func (lr *IceDBLogReader) ReadState(ctx context.Context, pathPrefix, offset string, maxMS, maxItems int64) (*LogSnapshot, error) {
+// ReadState folds the log files up to maxMS into the alive files (sorted by path) and schema.
+// The folded state is cached, so only log files after the last cached log file need to be read.
+func (lr *IceDBLogReader) ReadState(ctx context.Context, pathPrefix string, maxMS int64) (*LogSnapshot, error) {
 	if maxMS == 0 {
 		maxMS = time.Now().UnixMilli()
 	}
 	logger := zerolog.Ctx(ctx)
-	var contToken *string
-	snapshot := LogSnapshot{
-		AliveFiles: []FileMarker{},
-		Schema:     make(map[string]string),
+
+	// The policy changes the folded schema, so each policy has its own state
+	cacheKey := lr.storeID + "/" + pathPrefix + "|" + string(lr.schemaPolicy)
+	cached := logStateCache.Get(cacheKey)
+	if cached != nil && cached.state.lastTS > maxMS {
+		// Cached state is newer than the snapshot we want (time travel), must read from the start
+		cached = nil
 	}
-	var s3Files []types.Object
-	prefix := strings.Join([]string{pathPrefix, "_log"}, "/")
-	for {
-		logger.Debug().Str("prefix", prefix).Str("realBucket", *utils.S3BucketPtr).Msgf("listing s3 objects")
-
-		listObjects, err := lr.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-			Bucket:            utils.S3BucketPtr,
-			ContinuationToken: contToken,
-			MaxKeys:           1000,
-			Prefix:            &prefix,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("error in : %w", err)
-		}
-		logger.Debug().Msgf("got %d items in list", len(listObjects.Contents))
-		for _, object := range listObjects.Contents {
-			key := *object.Key
-			ts, _, err := getLogFileInfo(key)
-			if err != nil {
-				return nil, fmt.Errorf("error in getLogFileInfo for file %s: %w", *object.Key, err)
-			}
-			if ts <= maxMS {
-				s3Files = append(s3Files, object)
-			}
-		}
-		if !listObjects.IsTruncated {
-			// Break if we are over or if we are at the end of the list
-			break
-		}
+
+	startAfter := ""
+	if cached != nil {
+		startAfter = cached.state.lastKey
 	}
-	logger.Debug().Msgf("finished listing s3 objects with length %d", len(s3Files))
-	if len(s3Files) == 0 {
+	logFiles, err := lr.listLogFiles(ctx, pathPrefix, startAfter, maxMS)
+	if err != nil {
+		return nil, fmt.Errorf("error in listLogFiles: %w", err)
+	}
+	logFiles, err = fromNewestMerged(logFiles)
+	if err != nil {
+		return nil, fmt.Errorf("error in fromNewestMerged: %w", err)
+	}
+	logger.Debug().Bool("cached", cached != nil).Msgf("finished listing s3 objects with length %d", len(logFiles))
+	if cached != nil && len(logFiles) == 0 {
+		// Nothing new
+		return cached.snapshot, nil
+	}
+	if len(logFiles) == 0 {
 		return nil, ErrNoLogFiles
 	}
 
-	// Ensure they are sorted
-	slices.SortFunc(s3Files, func(a, b types.Object) int {
-		if *a.Key > *b.Key {
-			return 1
-		} else if *a.Key < *b.Key {
-			return -1
+	state := newFoldedState(lr.schemaPolicy)
+	if _, merged, _ := getLogFileInfo(logFiles[0]); cached != nil && !merged {
+		// A merged log file replaces the state, so the cached state is only built on without one
+		state = cached.state.clone()
+	}
+	parsedLogFiles, err := lr.readLogFiles(ctx, logFiles)
+	if err != nil {
+		return nil, fmt.Errorf("error in readLogFiles: %w", err)
+	}
+	for i, key := range logFiles {
+		err = state.apply(key, parsedLogFiles[i])
+		if err != nil {
+			return nil, fmt.Errorf("error applying log file %s: %w", key, err)
 		}
-		return 0
-	})
+	}
 
-	aliveFiles := map[string]FileMarker{}
-	for _, object := range s3Files {
-		obj, err := lr.s3Client.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: utils.S3BucketPtr,
-			Key:    object.Key,
-		})
+	if len(state.aliveFiles) == 0 {
+		return nil, ErrNoAliveFiles
+	}
+
+	snapshot := state.snapshot()
+	logStateCache.Put(cacheKey, state, snapshot)
+	return snapshot, nil
+}
+
+// listLogFiles lists the sorted keys of the log files after startAfter, up to maxMS
+func (lr *IceDBLogReader) listLogFiles(ctx context.Context, pathPrefix, startAfter string, maxMS int64) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	prefix := strings.Join([]string{pathPrefix, "_log"}, "/")
+	logger.Debug().Str("prefix", prefix).Str("startAfter", startAfter).Str("store", lr.storeID).Msgf("listing log files")
+
+	objects, err := lr.store.List(ctx, prefix, startAfter)
+	if err != nil {
+		return nil, fmt.Errorf("error in store.List: %w", err)
+	}
+	logger.Debug().Msgf("got %d items in list", len(objects))
+	var logFiles []string
+	for _, object := range objects {
+		ts, _, err := getLogFileInfo(object.Key)
 		if err != nil {
-			return nil, fmt.Errorf("error in GetObject for file %s: %w", *object.Key, err)
+			return nil, fmt.Errorf("error in getLogFileInfo for file %s: %w", object.Key, err)
 		}
-		defer obj.Body.Close()
-		fileBytes, err := io.ReadAll(obj.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error in io.ReadAll for file %s: %w", *object.Key, err)
+		if ts <= maxMS {
+			logFiles = append(logFiles, object.Key)
 		}
-		fileLines := strings.Split(string(fileBytes), "\n")
-		var meta LogMeta
-		err = sonic.Unmarshal([]byte(fileLines[0]), &meta)
+	}
+
+	// Ensure they are sorted
+	slices.Sort(logFiles)
+	return logFiles, nil
+}
+
+// fromNewestMerged drops the log files before the newest merged log file, as the merged log file
+// already contains their state. Keys must be sorted.
+func fromNewestMerged(keys []string) ([]string, error) {
+	for i := len(keys) - 1; i >= 0; i-- {
+		_, merged, err := getLogFileInfo(keys[i])
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling meta for file %s: %w", *object.Key, err)
+			return nil, fmt.Errorf("error in getLogFileInfo for file %s: %w", keys[i], err)
+		}
+		if merged {
+			return keys[i:], nil
 		}
+	}
+	return keys, nil
+}
 
-		var schema Schema
-		err = sonic.Unmarshal([]byte(fileLines[meta.SchemaStartLine]), &schema)
-		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling schema for file %s: %w", *object.Key, err)
+// readLogFiles fetches and parses the log files concurrently (up to LOG_FETCH_CONCURRENCY at a time),
+// returning them in the same order as the keys
+func (lr *IceDBLogReader) readLogFiles(ctx context.Context, keys []string) ([]*LogFile, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logFiles := make([]*LogFile, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, max(utils.LogFetchConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// Another fetch failed, or the caller's context is done
+			wg.Wait()
+			return nil, errors.Join(append(errs, ctx.Err())...)
 		}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			logFiles[i], errs[i] = lr.readLogFile(ctx, key)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, key)
+	}
+	wg.Wait()
+
+	// The caller's context may be done even if every fetch finished
+	if err := errors.Join(append(errs, ctx.Err())...); err != nil {
+		return nil, err
+	}
+	return logFiles, nil
+}
+
+func (lr *IceDBLogReader) readLogFile(ctx context.Context, key string) (*LogFile, error) {
+	obj, err := lr.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error in store.Get for file %s: %w", key, err)
+	}
+	defer obj.Body.Close()
+	fileBytes, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error in io.ReadAll for file %s: %w", key, err)
+	}
+	logFile, err := parseLogFile(fileBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error in parseLogFile for file %s: %w", key, err)
+	}
+	return logFile, nil
+}
+
+// parseLogFile parses the sections of a log file. Line 0 is the meta, which has the start line of the schema,
+// file marker, and (optional) tombstone sections. Each section runs until the next section or the end of the file.
+func parseLogFile(fileBytes []byte) (*LogFile, error) {
+	fileLines := strings.Split(strings.TrimRight(string(fileBytes), "\n"), "\n")
+	var logFile LogFile
+	err := sonic.Unmarshal([]byte(fileLines[0]), &logFile.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling meta: %w", err)
+	}
+	meta := logFile.Meta
+	if meta.Version != SupportedLogVersion {
+		return nil, fmt.Errorf("version %d: %w", meta.Version, ErrUnsupportedLogVersion)
+	}
 
-		// Aggregate the schema
-		for colName, colType := range schema {
-			if eType, exists := snapshot.Schema[colName]; exists && eType != colType {
-				return nil, fmt.Errorf("col %s types %s %s: %w", colName, colType, eType, ErrColumnTypeCollision)
-			} else {
-				snapshot.Schema[colName] = colType
+	sectionStarts := []int{meta.SchemaStartLine, meta.FileMarkerStartLine, utils.Deref(meta.TombstoneStartLine, 0)}
+	for _, start := range sectionStarts {
+		if start < 0 || start >= len(fileLines) {
+			return nil, fmt.Errorf("section start line %d with %d lines: %w", start, len(fileLines), ErrInvalidLogFile)
+		}
+	}
+	// sectionEnd is the start of the next section, or the end of the file
+	sectionEnd := func(start int) int {
+		end := len(fileLines)
+		for _, otherStart := range sectionStarts {
+			if otherStart > start && otherStart < end {
+				end = otherStart
 			}
 		}
+		return end
+	}
+
+	if meta.SchemaStartLine == 0 {
+		return nil, fmt.Errorf("missing schema: %w", ErrInvalidLogFile)
+	}
+	err = sonic.Unmarshal([]byte(fileLines[meta.SchemaStartLine]), &logFile.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling schema: %w", err)
+	}
 
-		// Determine alive files
-		for i := meta.FileMarkerStartLine; i < len(fileLines); i++ {
+	// A start line of 0 (the meta) means the section is omitted
+	if meta.FileMarkerStartLine != 0 {
+		for i := meta.FileMarkerStartLine; i < sectionEnd(meta.FileMarkerStartLine); i++ {
 			var fm FileMarker
 			err = sonic.Unmarshal([]byte(fileLines[i]), &fm)
 			if err != nil {
-				return nil, fmt.Errorf("error unmarshaling file marker line %d for file %s: %w", i, *object.Key, err)
+				return nil, fmt.Errorf("error unmarshaling file marker line %d: %w", i, err)
 			}
-			if _, exists := aliveFiles[fm.Path]; fm.Tombstone != nil && exists {
-				// found a tombstone for the file, remove it
-				delete(aliveFiles, fm.Path)
-			} else if fm.Tombstone == nil {
-				aliveFiles[fm.Path] = fm
+			logFile.FileMarkers = append(logFile.FileMarkers, fm)
+		}
+	}
+
+	if tmbStart := utils.Deref(meta.TombstoneStartLine, 0); tmbStart != 0 {
+		for i := tmbStart; i < sectionEnd(tmbStart); i++ {
+			var tmb Tombstone
+			err = sonic.Unmarshal([]byte(fileLines[i]), &tmb)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshaling tombstone line %d: %w", i, err)
 			}
+			logFile.Tombstones = append(logFile.Tombstones, tmb)
 		}
 	}
+	return &logFile, nil
+}
 
-	if len(aliveFiles) == 0 {
-		return nil, ErrNoAliveFiles
+func newFoldedState(schemaPolicy SchemaPolicy) *foldedState {
+	return &foldedState{
+		aliveFiles:   map[string]FileMarker{},
+		columns:      map[string]Column{},
+		schemaPolicy: schemaPolicy,
+	}
+}
+
+func (fs *foldedState) clone() *foldedState {
+	return &foldedState{
+		aliveFiles:   maps.Clone(fs.aliveFiles),
+		columns:      maps.Clone(fs.columns),
+		schemaPolicy: fs.schemaPolicy,
+		lastKey:      fs.lastKey,
+		lastTS:       fs.lastTS,
+	}
+}
+
+// apply folds a log file into the state, log files must be applied in order
+func (fs *foldedState) apply(key string, logFile *LogFile) error {
+	ts, _, err := getLogFileInfo(key)
+	if err != nil {
+		return fmt.Errorf("error in getLogFileInfo: %w", err)
+	}
+
+	// Aggregate the schema
+	for colName, colType := range logFile.Schema {
+		col, exists := fs.columns[colName]
+		if !exists {
+			fs.columns[colName] = Column{Type: colType, AddedMS: ts, ChangedMS: ts}
+			continue
+		}
+		resolvedType, err := fs.schemaPolicy.resolveType(colName, col.Type, colType)
+		if err != nil {
+			return err
+		}
+		if resolvedType != col.Type {
+			col.Type = resolvedType
+			col.ChangedMS = ts
+			fs.columns[colName] = col
+		}
 	}
 
-	// Need the final list to do offset and limit, otherwise we
-	for _, file := range aliveFiles {
+	// Determine alive files
+	for _, fm := range logFile.FileMarkers {
+		if _, exists := fs.aliveFiles[fm.Path]; fm.Tombstone != nil && exists {
+			// found a tombstone for the file, remove it
+			delete(fs.aliveFiles, fm.Path)
+		} else if fm.Tombstone == nil {
+			fs.aliveFiles[fm.Path] = fm
+		}
+	}
+
+	fs.lastKey = key
+	fs.lastTS = ts
+	return nil
+}
+
+func (fs *foldedState) snapshot() *LogSnapshot {
+	snapshot := LogSnapshot{
+		AliveFiles: make([]FileMarker, 0, len(fs.aliveFiles)),
+		Schema:     make(Schema, len(fs.columns)),
+		Columns:    maps.Clone(fs.columns),
+	}
+	for colName, col := range fs.columns {
+		snapshot.Schema[colName] = col.Type
+	}
+	for _, file := range fs.aliveFiles {
 		snapshot.AliveFiles = append(snapshot.AliveFiles, file)
 	}
 
 	// Sort
 	slices.SortFunc(snapshot.AliveFiles, func(a, b FileMarker) int {
-		if a.Path > b.Path {
-			return 1
-		}
-		if a.Path < b.Path {
-			return -1
-		}
-		return 0
+		return strings.Compare(a.Path, b.Path)
+	})
+	return &snapshot
+}
+
+// LastModified is when the file was added to the log
+func (fm FileMarker) LastModified() time.Time {
+	return time.UnixMilli(int64(fm.TimestampMS)).UTC()
+}
+
+// ETag is a stable entity tag for the file (quoted), derived from the marker since data files are immutable
+func (fm FileMarker) ETag() string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", fm.Path, fm.ByteLength, fm.TimestampMS)))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// AliveFile returns the file marker if the path (including the path prefix) is alive in the snapshot
+func (s *LogSnapshot) AliveFile(path string) (FileMarker, bool) {
+	ind, found := slices.BinarySearchFunc(s.AliveFiles, path, func(marker FileMarker, target string) int {
+		return strings.Compare(marker.Path, target)
 	})
+	if !found {
+		return FileMarker{}, false
+	}
+	return s.AliveFiles[ind], true
+}
+
+// Page returns a page of alive files after StartAfter, like ListObjectsV2. Files are filtered by Prefix, and
+// if a Delimiter is provided, files with the delimiter after the prefix are grouped into CommonPrefixes,
+// which count towards MaxItems. All paths include the path prefix.
+func (s *LogSnapshot) Page(opts PageOptions) PageResult {
+	res := PageResult{
+		Files:          []FileMarker{},
+		CommonPrefixes: []string{},
+	}
 
-	// Check for offset
-	if offset != "" {
-		ind := slices.IndexFunc(snapshot.AliveFiles, func(marker FileMarker) bool {
-			return marker.Path > offset
+	// Skip straight to the first file that could match
+	ind, _ := slices.BinarySearchFunc(s.AliveFiles, opts.Prefix, func(marker FileMarker, target string) int {
+		return strings.Compare(marker.Path, target)
+	})
+	if opts.StartAfter != "" {
+		afterInd, found := slices.BinarySearchFunc(s.AliveFiles, opts.StartAfter, func(marker FileMarker, target string) int {
+			return strings.Compare(marker.Path, target)
 		})
-		if ind != -1 && len(snapshot.AliveFiles) > ind {
-			snapshot.AliveFiles = snapshot.AliveFiles[ind+1:]
+		if found {
+			afterInd++
 		}
+		ind = max(ind, afterInd)
 	}
 
-	// Limit
-	if maxItems != 0 && maxItems < int64(len(snapshot.AliveFiles)) {
-		snapshot.AliveFiles = snapshot.AliveFiles[:maxItems]
+	var count int64
+	for _, file := range s.AliveFiles[ind:] {
+		if !strings.HasPrefix(file.Path, opts.Prefix) {
+			// Sorted, so nothing after will match either
+			break
+		}
+
+		commonPrefix := ""
+		if opts.Delimiter != "" {
+			if delimInd := strings.Index(file.Path[len(opts.Prefix):], opts.Delimiter); delimInd != -1 {
+				commonPrefix = file.Path[:len(opts.Prefix)+delimInd+len(opts.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && (commonPrefix == res.LastKey || commonPrefix == opts.StartAfter) {
+			// Already rolled up into this common prefix
+			continue
+		}
+
+		if count == opts.MaxItems {
+			res.Truncated = true
+			break
+		}
+		count++
+
+		if commonPrefix != "" {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix)
+			res.LastKey = commonPrefix
+		} else {
+			res.Files = append(res.Files, file)
+			res.LastKey = file.Path
+		}
 	}
 
-	return &snapshot, nil
+	return res
 }
 
 func getLogFileInfo(fileName string) (int64, bool, error) {
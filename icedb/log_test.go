@@ -3,56 +3,366 @@ package icedb
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/storage"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
 
+// writeTestTable writes a table with a log file per batch of data files, and a log file tombstoning the first file
+func writeTestTable(t *testing.T, root string) {
+	t.Helper()
+	logDir := filepath.Join(root, "bucket", "tenant", "_log")
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for batch := 0; batch < 3; batch++ {
+		ts := 1700000000000 + batch*1000
+		lines := []string{fmt.Sprintf(`{"v":1,"t":%d,"sch":1,"f":2}`, ts), `{"user_id":"VARCHAR"}`}
+		for i := 0; i < 100; i++ {
+			lines = append(lines, fmt.Sprintf(`{"p":"tenant/_data/%d_%03d.parquet","b":100,"t":%d}`, batch, i, ts))
+		}
+		if batch == 2 {
+			lines = append(lines, `{"p":"tenant/_data/0_000.parquet","b":100,"t":1700000000000,"tmb":1700000002000}`)
+		}
+		fileName := filepath.Join(logDir, fmt.Sprintf("%d_batch%d.jsonl", ts, batch))
+		if err := os.WriteFile(fileName, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestReadLog(t *testing.T) {
-	i, err := NewIceDBLogReader(context.Background())
+	root := t.TempDir()
+	writeTestTable(t, root)
+	i, err := NewIceDBLogReader(context.Background(), storage.Target{Bucket: "bucket", Endpoint: "file://" + root}, SchemaPolicyStrict)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	snap, err := i.ReadState(context.Background(), "tenant", "", time.Now().UnixMilli(), 1000)
+	snap, err := i.ReadState(context.Background(), "tenant", time.Now().UnixMilli())
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(snap.AliveFiles) != 299 {
+		t.Fatalf("expected 299 alive files, got %d", len(snap.AliveFiles))
+	}
+	if _, alive := snap.AliveFile("tenant/_data/0_000.parquet"); alive {
+		t.Fatal("tombstoned file is alive")
+	}
 
-	// t.Logf("%+v, %d", snap, len(snap.AliveFiles))
+	// Time travel to before the later batches
+	oldSnap, err := i.ReadState(context.Background(), "tenant", 1700000000500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(oldSnap.AliveFiles) != 100 {
+		t.Fatalf("expected 100 alive files at the first batch, got %d", len(oldSnap.AliveFiles))
+	}
 
-	snap, err = i.ReadState(context.Background(), "tenant", "", 0, 1000)
+	_, err = i.ReadState(context.Background(), "tenant", 1)
 	if !errors.Is(err, ErrNoLogFiles) {
 		t.Fatal("found files?")
 	}
 
 	t.Log("Checking with limit and offset")
-	snap, err = i.ReadState(context.Background(), "tenant", "", time.Now().UnixMilli(), 100)
+	page := snap.Page(PageOptions{MaxItems: 100})
+	items := page.Files
+	if len(items) != 100 {
+		t.Fatalf("did not limit to 100, got %d", len(items))
+	}
+	if !page.Truncated {
+		t.Fatal("first page was not truncated")
+	}
+
+	items2 := snap.Page(PageOptions{StartAfter: page.LastKey, MaxItems: 100}).Files
+
+	t.Log("Got offsets", items[len(items)-1].Path, items2[0].Path)
+
+	if items2[0].Path <= items[len(items)-1].Path {
+		t.Fatal("Second page was not greater than offset")
+	}
+}
+
+func TestReadLogFilesCanceled(t *testing.T) {
+	root := t.TempDir()
+	writeTestTable(t, root)
+	lr, err := NewIceDBLogReader(context.Background(), storage.Target{Bucket: "bucket", Endpoint: "file://" + root}, SchemaPolicyStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := lr.listLogFiles(context.Background(), "tenant", "", time.Now().UnixMilli())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	items := []FileMarker{}
-	for _, val := range snap.AliveFiles {
-		items = append(items, val)
+	// e.g. the client disconnected
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	logFiles, err := lr.readLogFiles(ctx, keys)
+	if !errors.Is(err, context.Canceled) || logFiles != nil {
+		t.Fatalf("expected canceled, got %d files and %v", len(logFiles), err)
 	}
+}
 
-	if len(items) != 100 {
-		t.Fatalf("did not limit to 100, got %d", len(items))
+func TestPage(t *testing.T) {
+	snap := LogSnapshot{
+		AliveFiles: []FileMarker{{Path: "a"}, {Path: "b"}, {Path: "c"}},
+	}
+
+	page := snap.Page(PageOptions{MaxItems: 2})
+	if len(page.Files) != 2 || page.Files[0].Path != "a" || !page.Truncated {
+		t.Fatalf("bad first page %+v", page)
+	}
+
+	page = snap.Page(PageOptions{StartAfter: page.LastKey, MaxItems: 2})
+	if len(page.Files) != 1 || page.Files[0].Path != "c" || page.Truncated {
+		t.Fatalf("bad second page %+v", page)
 	}
 
-	snap, err = i.ReadState(context.Background(), "tenant", items[len(items)-1].Path, time.Now().UnixMilli(), 100)
+	page = snap.Page(PageOptions{StartAfter: "c", MaxItems: 2})
+	if len(page.Files) != 0 || page.Truncated {
+		t.Fatalf("bad empty page %+v", page)
+	}
+}
+
+func TestPageDelimiter(t *testing.T) {
+	snap := LogSnapshot{
+		AliveFiles: []FileMarker{
+			{Path: "t/_data/year=2023/month=12/a.parquet"},
+			{Path: "t/_data/year=2024/month=01/a.parquet"},
+			{Path: "t/_data/year=2024/month=01/b.parquet"},
+			{Path: "t/_data/year=2024/month=02/a.parquet"},
+			{Path: "t/_data/year=2024/top.parquet"},
+			{Path: "t/_data/year=2025/month=01/a.parquet"},
+		},
+	}
+
+	page := snap.Page(PageOptions{Prefix: "t/_data/year=2024/", Delimiter: "/", MaxItems: 1000})
+	if !slices.Equal(page.CommonPrefixes, []string{"t/_data/year=2024/month=01/", "t/_data/year=2024/month=02/"}) {
+		t.Fatalf("bad common prefixes %+v", page.CommonPrefixes)
+	}
+	if len(page.Files) != 1 || page.Files[0].Path != "t/_data/year=2024/top.parquet" || page.Truncated {
+		t.Fatalf("bad files %+v", page)
+	}
+
+	// Common prefixes count towards max items, and continuing skips the rest of the rolled up prefix
+	page = snap.Page(PageOptions{Prefix: "t/_data/year=2024/", Delimiter: "/", MaxItems: 1})
+	if !slices.Equal(page.CommonPrefixes, []string{"t/_data/year=2024/month=01/"}) || !page.Truncated {
+		t.Fatalf("bad first page %+v", page)
+	}
+	page = snap.Page(PageOptions{Prefix: "t/_data/year=2024/", Delimiter: "/", StartAfter: page.LastKey, MaxItems: 1})
+	if !slices.Equal(page.CommonPrefixes, []string{"t/_data/year=2024/month=02/"}) || !page.Truncated {
+		t.Fatalf("bad second page %+v", page)
+	}
+}
+
+func TestFromNewestMerged(t *testing.T) {
+	keys := []string{
+		"t/_log/1000_a.jsonl",
+		"t/_log/2000_m_b.jsonl",
+		"t/_log/3000_c.jsonl",
+		"t/_log/4000_m_d.jsonl",
+		"t/_log/5000_e.jsonl",
+	}
+	res, err := fromNewestMerged(keys)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !slices.Equal(res, []string{"t/_log/4000_m_d.jsonl", "t/_log/5000_e.jsonl"}) {
+		t.Fatalf("bad keys %+v", res)
+	}
 
-	items2 := []FileMarker{}
-	for _, val := range snap.AliveFiles {
-		items2 = append(items2, val)
+	res, err = fromNewestMerged(keys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res, keys[:1]) {
+		t.Fatalf("bad keys without merge %+v", res)
 	}
+}
 
-	t.Log("Got offsets", items[len(items)-1].Path, items2[0].Path)
+func readFixture(t *testing.T, name string) *LogFile {
+	t.Helper()
+	fileBytes, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := parseLogFile(fileBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logFile
+}
 
-	if items2[0].Path <= items[len(items)-1].Path {
-		t.Fatal("Second page was not greater than offset")
+func alivePaths(snap *LogSnapshot) []string {
+	var paths []string
+	for _, fm := range snap.AliveFiles {
+		paths = append(paths, fm.Path)
+	}
+	return paths
+}
+
+func TestParseLogFileSections(t *testing.T) {
+	normal := readFixture(t, "normal.jsonl")
+	if len(normal.FileMarkers) != 2 || len(normal.Tombstones) != 0 || normal.Schema["ts"] != "BIGINT" {
+		t.Fatalf("bad normal log file %+v", normal)
+	}
+
+	// Tombstones may come before or after the file markers, and must never be read as file markers
+	for _, name := range []string{"merged_tombstones_first.jsonl", "merged_tombstones_last.jsonl"} {
+		merged := readFixture(t, name)
+		if len(merged.FileMarkers) != 1 || merged.FileMarkers[0].Path != "tenant/_data/d.parquet" {
+			t.Fatalf("bad file markers in %s %+v", name, merged.FileMarkers)
+		}
+		if len(merged.Tombstones) != 2 || merged.Tombstones[0].Path != "tenant/_log/1700000000000_a.jsonl" {
+			t.Fatalf("bad tombstones in %s %+v", name, merged.Tombstones)
+		}
+	}
+
+	fileBytes, err := os.ReadFile("testdata/bad_version.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseLogFile(fileBytes)
+	if !errors.Is(err, ErrUnsupportedLogVersion) {
+		t.Fatalf("expected unsupported version, got %v", err)
+	}
+
+	_, err = parseLogFile([]byte(`{"v":1,"t":1,"sch":1,"f":5}` + "\n" + `{"a":"VARCHAR"}`))
+	if !errors.Is(err, ErrInvalidLogFile) {
+		t.Fatalf("expected invalid log file, got %v", err)
+	}
+}
+
+func TestApplyTombstones(t *testing.T) {
+	state := newFoldedState(SchemaPolicyStrict)
+	err := state.apply("tenant/_log/1700000000000_a.jsonl", readFixture(t, "normal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.apply("tenant/_log/1700000001000_b.jsonl", readFixture(t, "tombstoned_marker.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := alivePaths(state.snapshot()); !slices.Equal(paths, []string{"tenant/_data/b.parquet", "tenant/_data/c.parquet"}) {
+		t.Fatalf("bad alive files %+v", paths)
+	}
+
+	// A merged log file on its own only has the files listed in it alive, its log tombstones don't remove data
+	state = newFoldedState(SchemaPolicyStrict)
+	err = state.apply("tenant/_log/1700000002000_m_c.jsonl", readFixture(t, "merged_tombstones_first.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := alivePaths(state.snapshot()); !slices.Equal(paths, []string{"tenant/_data/d.parquet"}) {
+		t.Fatalf("bad alive files after merge %+v", paths)
+	}
+}
+
+// copyFixtureLog copies a testdata log file into the table's log as key
+func copyFixtureLog(t *testing.T, root, key, name string) {
+	t.Helper()
+	fileBytes, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(root, "bucket", filepath.FromSlash(key))
+	if err = os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(fileName, fileBytes, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadStateCachedMerge(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	copyFixtureLog(t, root, "tenant/_log/1700000000000_a.jsonl", "normal.jsonl")
+	copyFixtureLog(t, root, "tenant/_log/1700000001000_b.jsonl", "tombstoned_marker.jsonl")
+	lr, err := NewIceDBLogReader(ctx, storage.Target{Bucket: "bucket", Endpoint: "file://" + root}, SchemaPolicyStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lr.ReadState(ctx, "tenant", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The merge drops files that are alive in the cached state
+	copyFixtureLog(t, root, "tenant/_log/1700000002000_m_c.jsonl", "merged_tombstones_first.jsonl")
+	cached, err := lr.ReadState(ctx, "tenant", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logStateCache.remove(logStateCache.entries[lr.storeID+"/tenant|"+string(SchemaPolicyStrict)])
+	cold, err := lr.ReadState(ctx, "tenant", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(alivePaths(cached), alivePaths(cold)) || !slices.Equal(alivePaths(cold), []string{"tenant/_data/d.parquet"}) {
+		t.Fatalf("cached read %+v does not match cold read %+v", alivePaths(cached), alivePaths(cold))
+	}
+}
+
+func TestSchemaPolicy(t *testing.T) {
+	logFiles := []struct {
+		key    string
+		schema Schema
+	}{
+		{"t/_log/1000_a.jsonl", Schema{"id": "Int32", "name": "String"}},
+		{"t/_log/2000_b.jsonl", Schema{"id": "Int64", "ts": "Int64"}},
+		{"t/_log/3000_c.jsonl", Schema{"id": "Int32", "name": "String"}},
+	}
+	fold := func(policy SchemaPolicy) (*LogSnapshot, error) {
+		state := newFoldedState(policy)
+		for _, lf := range logFiles {
+			err := state.apply(lf.key, &LogFile{Schema: lf.schema})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return state.snapshot(), nil
+	}
+
+	_, err := fold(SchemaPolicyStrict)
+	if !errors.Is(err, ErrColumnTypeCollision) {
+		t.Fatalf("expected collision with strict, got %v", err)
+	}
+
+	// Widen keeps Int64 even though a later file has Int32
+	snap, err := fold(SchemaPolicyWiden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := snap.Columns["id"]; id != (Column{Type: "Int64", AddedMS: 1000, ChangedMS: 2000}) {
+		t.Fatalf("bad widened column %+v", id)
+	}
+	if ts := snap.Columns["ts"]; ts != (Column{Type: "Int64", AddedMS: 2000, ChangedMS: 2000}) || snap.Schema["ts"] != "Int64" {
+		t.Fatalf("bad added column %+v", ts)
+	}
+
+	snap, err = fold(SchemaPolicyLatestWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := snap.Columns["id"]; id != (Column{Type: "Int32", AddedMS: 1000, ChangedMS: 3000}) {
+		t.Fatalf("bad latest wins column %+v", id)
+	}
+
+	// Narrowing or unrelated types can't be widened
+	state := newFoldedState(SchemaPolicyWiden)
+	_ = state.apply("t/_log/1000_a.jsonl", &LogFile{Schema: Schema{"id": "Int64"}})
+	err = state.apply("t/_log/2000_b.jsonl", &LogFile{Schema: Schema{"id": "String"}})
+	if !errors.Is(err, ErrColumnTypeCollision) {
+		t.Fatalf("expected collision widening to String, got %v", err)
+	}
+
+	if _, err = ParseSchemaPolicy("loose"); !errors.Is(err, ErrUnknownSchemaPolicy) {
+		t.Fatalf("expected unknown policy, got %v", err)
 	}
 }
@@ -0,0 +1,84 @@
+package icedb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrUnknownSchemaPolicy = errors.New("unknown schema policy")
+)
+
+// SchemaPolicy decides what happens when log files disagree on the type of a column
+type SchemaPolicy string
+
+const (
+	// SchemaPolicyStrict fails the read with ErrColumnTypeCollision
+	SchemaPolicyStrict SchemaPolicy = "strict"
+	// SchemaPolicyWiden takes the wider type if one is a safe promotion of the other, otherwise fails like strict
+	SchemaPolicyWiden SchemaPolicy = "widen"
+	// SchemaPolicyLatestWins takes the type from the newest log file
+	SchemaPolicyLatestWins SchemaPolicy = "latest-wins"
+)
+
+// widenChains are the safe promotions, each type can widen to any type after it in its chain.
+// Covers both ClickHouse and DuckDB type names.
+var widenChains = [][]string{
+	{"Int8", "Int16", "Int32", "Int64", "Int128", "Int256"},
+	{"UInt8", "UInt16", "UInt32", "UInt64", "UInt128", "UInt256"},
+	{"Float32", "Float64"},
+	{"TINYINT", "SMALLINT", "INTEGER", "BIGINT", "HUGEINT"},
+	{"UTINYINT", "USMALLINT", "UINTEGER", "UBIGINT"},
+	{"FLOAT", "DOUBLE"},
+	{"REAL", "DOUBLE"},
+}
+
+type (
+	// Column is a column of the merged schema, with when it was added and last changed type
+	Column struct {
+		Type      string
+		AddedMS   int64
+		ChangedMS int64
+	}
+)
+
+// ParseSchemaPolicy parses a policy name, "" is strict
+func ParseSchemaPolicy(s string) (SchemaPolicy, error) {
+	switch policy := SchemaPolicy(s); policy {
+	case "":
+		return SchemaPolicyStrict, nil
+	case SchemaPolicyStrict, SchemaPolicyWiden, SchemaPolicyLatestWins:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("policy '%s': %w", s, ErrUnknownSchemaPolicy)
+	}
+}
+
+// canWiden returns whether from can be safely promoted to to
+func canWiden(from, to string) bool {
+	for _, chain := range widenChains {
+		fromInd, toInd := slices.Index(chain, from), slices.Index(chain, to)
+		if fromInd != -1 && toInd != -1 && fromInd <= toInd {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveType returns the type a column should have when a newer log file says it is newType
+func (p SchemaPolicy) resolveType(colName, existingType, newType string) (string, error) {
+	switch {
+	case existingType == newType:
+		return existingType, nil
+	case p == SchemaPolicyLatestWins:
+		return newType, nil
+	case p == SchemaPolicyWiden && canWiden(existingType, newType):
+		return newType, nil
+	case p == SchemaPolicyWiden && canWiden(newType, existingType):
+		// Older files already have the wider type
+		return existingType, nil
+	default:
+		return "", fmt.Errorf("col %s types %s %s: %w", colName, newType, existingType, ErrColumnTypeCollision)
+	}
+}
@@ -0,0 +1,103 @@
+package icedb
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+// logStateCache holds the folded state of each table, shared by all readers
+var logStateCache = newStateCache(utils.LogCacheBytes)
+
+type (
+	// stateCache is an LRU of folded log states with a byte budget. Log files are immutable, so a
+	// folded state stays valid and only needs the log files after its last key applied.
+	stateCache struct {
+		maxBytes  int64
+		usedBytes int64
+		lru       *list.List // of *stateCacheEntry, front is most recent
+		entries   map[string]*list.Element
+		mu        sync.Mutex
+	}
+
+	stateCacheEntry struct {
+		key      string
+		state    *foldedState
+		snapshot *LogSnapshot
+		size     int64
+	}
+)
+
+func newStateCache(maxBytes int64) *stateCache {
+	return &stateCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get returns the cached entry, which must not be modified
+func (c *stateCache) Get(key string) *stateCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*stateCacheEntry)
+}
+
+// Put caches the state, unless a state further along the log is already cached
+func (c *stateCache) Put(key string, state *foldedState, snapshot *LogSnapshot) {
+	entry := &stateCacheEntry{
+		key:      key,
+		state:    state,
+		snapshot: snapshot,
+		size:     state.size(),
+	}
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, exists := c.entries[key]; exists {
+		existing := elem.Value.(*stateCacheEntry)
+		if existing.state.lastKey >= state.lastKey {
+			// Already have a newer state (e.g. this was a time travel read)
+			c.lru.MoveToFront(elem)
+			return
+		}
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	c.usedBytes += entry.size
+	for c.usedBytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *stateCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*stateCacheEntry)
+	delete(c.entries, entry.key)
+	c.usedBytes -= entry.size
+}
+
+// size is an estimate of the memory used by the state and its snapshot
+func (fs *foldedState) size() int64 {
+	// The marker is held in both the map and the snapshot slice
+	const fileMarkerOverhead = 2 * 64
+	var size int64
+	for path := range fs.aliveFiles {
+		size += int64(2*len(path)) + fileMarkerOverhead
+	}
+	// Columns are held in the map, and the snapshot's columns and schema
+	const columnOverhead = 2 * 16
+	for colName, col := range fs.columns {
+		size += int64(3*(len(colName)+len(col.Type))) + columnOverhead
+	}
+	return size
+}
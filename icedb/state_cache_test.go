@@ -0,0 +1,137 @@
+package icedb
+
+import (
+	"context"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/storage"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testFoldedState has an alive file per path, after the log file lastKey
+func testFoldedState(lastKey string, paths ...string) *foldedState {
+	state := newFoldedState(SchemaPolicyStrict)
+	for _, path := range paths {
+		state.aliveFiles[path] = FileMarker{Path: path}
+	}
+	state.lastKey = lastKey
+	return state
+}
+
+func TestStateCacheByteBudget(t *testing.T) {
+	entrySize := testFoldedState("", "a").size()
+	cache := newStateCache(2 * entrySize)
+	cache.Put("a", testFoldedState("1", "a"), nil)
+	cache.Put("b", testFoldedState("1", "b"), nil)
+	if cache.usedBytes != 2*entrySize || cache.lru.Len() != 2 {
+		t.Fatalf("expected 2 entries of %d bytes, got %d entries of %d bytes", entrySize, cache.lru.Len(), cache.usedBytes)
+	}
+
+	// Larger than the whole budget, so never cached
+	cache.Put("big", testFoldedState("1", "a", "b", "c"), nil)
+	if cache.Get("big") != nil || cache.Get("a") == nil || cache.Get("b") == nil {
+		t.Fatal("state over the budget was cached, or evicted others")
+	}
+
+	cache.Put("c", testFoldedState("1", "c"), nil)
+	if cache.usedBytes > cache.maxBytes || len(cache.entries) != 2 {
+		t.Fatalf("used %d bytes of %d with %d entries", cache.usedBytes, cache.maxBytes, len(cache.entries))
+	}
+}
+
+func TestStateCacheEvictionOrder(t *testing.T) {
+	cache := newStateCache(3 * testFoldedState("", "a").size())
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Put(key, testFoldedState("1", key), nil)
+	}
+
+	// Reading a makes b the least recently used
+	cache.Get("a")
+	cache.Put("d", testFoldedState("1", "d"), nil)
+	if cache.Get("b") != nil {
+		t.Fatal("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if cache.Get(key) == nil {
+			t.Fatalf("entry %s was evicted", key)
+		}
+	}
+}
+
+func TestStateCachePutKeepsNewer(t *testing.T) {
+	cache := newStateCache(1 << 20)
+	cache.Put("table", testFoldedState("tenant/_log/2_b.jsonl", "a", "b"), nil)
+	usedBytes := cache.usedBytes
+
+	// e.g. a time travel read finishing after a read of the latest state
+	cache.Put("table", testFoldedState("tenant/_log/1_a.jsonl", "a"), nil)
+	if entry := cache.Get("table"); entry.state.lastKey != "tenant/_log/2_b.jsonl" || cache.usedBytes != usedBytes {
+		t.Fatalf("older state replaced newer, cached %s", entry.state.lastKey)
+	}
+
+	cache.Put("table", testFoldedState("tenant/_log/3_c.jsonl", "a"), nil)
+	if entry := cache.Get("table"); entry.state.lastKey != "tenant/_log/3_c.jsonl" || cache.usedBytes != entry.size {
+		t.Fatalf("newer state was not cached, cached %s", entry.state.lastKey)
+	}
+}
+
+// listRecorder records the startAfter of each list
+type listRecorder struct {
+	storage.ObjectStore
+	startAfters []string
+}
+
+func (l *listRecorder) List(ctx context.Context, prefix, startAfter string) ([]storage.ObjectInfo, error) {
+	l.startAfters = append(l.startAfters, startAfter)
+	return l.ObjectStore.List(ctx, prefix, startAfter)
+}
+
+func TestReadStateIncremental(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	writeTestTable(t, root)
+	store, err := storage.NewObjectStore(ctx, storage.Target{Bucket: "bucket", Endpoint: "file://" + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &listRecorder{ObjectStore: store}
+	lr := NewIceDBLogReaderFromStore(recorder, t.Name(), SchemaPolicyStrict)
+
+	first, err := lr.ReadState(ctx, "tenant", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing new, so the cached snapshot is returned
+	second, err := lr.ReadState(ctx, "tenant", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Fatal("unchanged log was not served from the cache")
+	}
+
+	fileName := filepath.Join(root, "bucket", "tenant", "_log", "1700000003000_batch3.jsonl")
+	err = os.WriteFile(fileName, []byte(`{"v":1,"t":1700000003000,"sch":1,"f":2}
+{"user_id":"VARCHAR"}
+{"p":"tenant/_data/3_000.parquet","b":100,"t":1700000003000}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := lr.ReadState(ctx, "tenant", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastKey := "tenant/_log/1700000002000_batch2.jsonl"
+	if !slices.Equal(recorder.startAfters, []string{"", lastKey, lastKey}) {
+		t.Fatalf("expected lists after the cached log file, got %q", recorder.startAfters)
+	}
+	if len(third.AliveFiles) != len(first.AliveFiles)+1 || !slices.Contains(alivePaths(third), "tenant/_data/3_000.parquet") {
+		t.Fatalf("expected the new file on top of the %d cached, got %d", len(first.AliveFiles), len(third.AliveFiles))
+	}
+	if _, exists := logStateCache.entries[fmt.Sprintf("%s/tenant|%s", t.Name(), SchemaPolicyStrict)]; !exists {
+		t.Fatal("state was not cached")
+	}
+}
@@ -0,0 +1,313 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/mailgun/groupcache/v2"
+	"github.com/rs/zerolog"
+	"github.com/samber/lo"
+	"github.com/uber-go/tally/v4"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	poolServer *http.Server
+)
+
+type (
+	// CachedResolver caches the resolutions of another resolver in groupcache, shared across the CACHE_PEERS.
+	// The last known good resolutions are also kept locally, so they can be served for CACHE_STALE_SECONDS
+	// past expiry while refreshing in the background (or while the resolver is down).
+	CachedResolver struct {
+		group      *groupcache.Group
+		pool       *groupcache.HTTPPool
+		local      sync.Map // cache key -> localEntry
+		refreshing sync.Map // cache key -> struct{}
+
+		// The cache keys this node has loaded or holds locally per virtual bucket, so they can be invalidated.
+		// Keys without a local entry are pruned when the local entries are swept.
+		keys   map[string]map[string]struct{}
+		keysMu sync.Mutex
+
+		peers   []string
+		peersMu sync.RWMutex
+
+		metrics peerMetrics
+	}
+
+	peerMetrics struct {
+		// Number of groupcache peers, including self
+		peers tally.Gauge
+		// Number of times the discovered peer membership changed
+		membershipChanges tally.Counter
+		// Number of failed peer discoveries
+		discoveryErrors tally.Counter
+	}
+
+	// cacheEntry is what is stored in groupcache. Errors do not survive being passed between peers, so
+	// denials and unknown buckets are stored as values.
+	cacheEntry struct {
+		Res         *VirtualBucketResolveRes `json:",omitempty"`
+		Denied      bool                     `json:",omitempty"`
+		NotFound    bool                     `json:",omitempty"`
+		FetchedAtMS int64
+	}
+
+	localEntry struct {
+		entry      cacheEntry
+		freshUntil time.Time
+		staleUntil time.Time
+	}
+)
+
+func NewCachedResolver(ctx context.Context, inner Resolver, scope tally.Scope) *CachedResolver {
+	logger := zerolog.Ctx(ctx)
+	cacheScope := scope.SubScope("cache")
+	r := &CachedResolver{
+		pool: groupcache.NewHTTPPoolOpts(utils.CacheSelfAddr, &groupcache.HTTPPoolOptions{}),
+		keys: map[string]map[string]struct{}{},
+		metrics: peerMetrics{
+			peers:             cacheScope.Gauge("peers"),
+			membershipChanges: cacheScope.Counter("peer_membership_changes"),
+			discoveryErrors:   cacheScope.Counter("peer_discovery_errors"),
+		},
+	}
+
+	// Add more peers to the cluster You MUST Ensure our instance is included in this list else
+	// determining who owns the key across the cluster will not be consistent, and the pool won't
+	// be able to determine if our instance owns the key. With peer discovery we start as a single node
+	// until the first discovery.
+	if utils.CachePeerDNS != "" || utils.CachePeerFile != "" {
+		r.SetPeers(utils.CacheSelfAddr)
+	} else {
+		r.SetPeers(utils.CachePeers...)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/_groupcache/", r.pool)
+	mux.HandleFunc(peerInvalidatePath, r.handlePeerInvalidate)
+	poolServer = &http.Server{
+		Addr:    strings.Split(utils.CacheSelfAddr, "://")[1],
+		Handler: mux,
+	}
+
+	// Start an HTTP server to listen for peer requests from the groupcache
+	go func() {
+		logger.Debug().Msg("cache pool server listening...")
+		if err := poolServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("error on pool server listen")
+		}
+	}()
+
+	r.group = groupcache.NewGroup("virtual_buckets", 3000000, groupcache.GetterFunc(
+		func(ctx context.Context, key string, dest groupcache.Sink) error {
+			var req VirtualBucketResolveReq
+			err := sonic.UnmarshalString(key, &req)
+			if err != nil {
+				return fmt.Errorf("error in sonic.UnmarshalString: %w", err)
+			}
+			r.trackKey(req.VirtualBucket, key)
+
+			entry := cacheEntry{
+				FetchedAtMS: time.Now().UnixMilli(),
+			}
+			entry.Res, err = inner.Resolve(ctx, req)
+			if errors.Is(err, ErrAccessDenied) {
+				entry.Denied = true
+			} else if errors.Is(err, ErrLookupNotFound) {
+				entry.NotFound = true
+			} else if err != nil {
+				return fmt.Errorf("error in inner.Resolve: %w", err)
+			}
+
+			jBytes, err := sonic.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("error in sonic.Marshal: %w", err)
+			}
+
+			return dest.SetBytes(jBytes, entry.freshUntil())
+		},
+	))
+
+	go r.sweepLocal(ctx)
+	if utils.CachePeerDNS != "" || utils.CachePeerFile != "" {
+		go r.discoverPeers(ctx)
+	}
+	return r
+}
+
+func (r *CachedResolver) Resolve(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+	key, err := cacheKey(req)
+	if err != nil {
+		return nil, fmt.Errorf("error in cacheKey: %w", err)
+	}
+
+	if l, ok := r.local.Load(key); ok {
+		le := l.(localEntry)
+		now := time.Now()
+		if now.Before(le.freshUntil) {
+			return le.entry.result()
+		}
+		if now.Before(le.staleUntil) {
+			r.refreshInBackground(ctx, req.VirtualBucket, key)
+			return le.entry.result()
+		}
+		r.local.Delete(key)
+	}
+
+	entry, err := r.fetch(ctx, req.VirtualBucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("error in fetch: %w", err)
+	}
+	return entry.result()
+}
+
+// fetch gets the entry from groupcache and updates the local last known good entry
+func (r *CachedResolver) fetch(ctx context.Context, virtualBucket, key string) (*cacheEntry, error) {
+	var resBytes []byte
+	if err := r.group.Get(ctx, key, groupcache.AllocatingByteSliceSink(&resBytes)); err != nil {
+		return nil, fmt.Errorf("error getting from groupcache: %w", fromPeerError(err))
+	}
+
+	var entry cacheEntry
+	err := sonic.Unmarshal(resBytes, &entry)
+	if err != nil {
+		return nil, fmt.Errorf("error in sonic.Unmarshal: %w", err)
+	}
+
+	le := localEntry{
+		entry:      entry,
+		freshUntil: entry.freshUntil(),
+		staleUntil: entry.freshUntil(),
+	}
+	if entry.Res != nil {
+		le.staleUntil = le.freshUntil.Add(time.Second * time.Duration(utils.CacheStaleSeconds))
+	}
+	r.local.Store(key, le)
+	r.trackKey(virtualBucket, key)
+	return &entry, nil
+}
+
+func (r *CachedResolver) refreshInBackground(ctx context.Context, virtualBucket, key string) {
+	if _, loaded := r.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		// Already refreshing
+		return
+	}
+	logger := zerolog.Ctx(ctx)
+	go func() {
+		defer r.refreshing.Delete(key)
+		// Detached from the request so it isn't canceled when the request finishes
+		refreshCtx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Second*30)
+		defer cancel()
+		if _, err := r.fetch(refreshCtx, virtualBucket, key); err != nil {
+			logger.Warn().Err(err).Str("key", key).Msg("error refreshing stale resolution, serving last known good")
+		}
+	}()
+}
+
+// SetPeers sets the groupcache peers, which must include self
+func (r *CachedResolver) SetPeers(peers ...string) {
+	r.peersMu.Lock()
+	defer r.peersMu.Unlock()
+	r.peers = peers
+	r.pool.Set(peers...)
+	r.metrics.peers.Update(float64(len(peers)))
+}
+
+func (r *CachedResolver) trackKey(virtualBucket, key string) {
+	r.keysMu.Lock()
+	defer r.keysMu.Unlock()
+	if _, exists := r.keys[virtualBucket]; !exists {
+		r.keys[virtualBucket] = map[string]struct{}{}
+	}
+	r.keys[virtualBucket][key] = struct{}{}
+}
+
+// sweepLocal periodically removes local entries that are past the stale window
+func (r *CachedResolver) sweepLocal(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.sweepLocalOnce(time.Now())
+	}
+}
+
+// sweepLocalOnce removes the local entries past the stale window at now, and the tracked keys without a local entry
+func (r *CachedResolver) sweepLocalOnce(now time.Time) {
+	r.local.Range(func(key, value any) bool {
+		if now.After(value.(localEntry).staleUntil) {
+			r.local.Delete(key)
+		}
+		return true
+	})
+
+	r.keysMu.Lock()
+	defer r.keysMu.Unlock()
+	for virtualBucket, bucketKeys := range r.keys {
+		for key := range bucketKeys {
+			if _, exists := r.local.Load(key); !exists {
+				delete(bucketKeys, key)
+			}
+		}
+		if len(bucketKeys) == 0 {
+			delete(r.keys, virtualBucket)
+		}
+	}
+}
+
+func (e *cacheEntry) freshUntil() time.Time {
+	ttl := lo.Ternary(e.Res == nil, utils.CacheNegativeSeconds, utils.CacheTTLSeconds)
+	return time.UnixMilli(e.FetchedAtMS).Add(time.Second * time.Duration(ttl))
+}
+
+func (e *cacheEntry) result() (*VirtualBucketResolveRes, error) {
+	if e.Denied {
+		return nil, ErrAccessDenied
+	}
+	if e.NotFound {
+		return nil, ErrLookupNotFound
+	}
+	return e.Res, nil
+}
+
+// peerErrors are mapped back from the message of a failed load on the owning peer, as errors do not
+// survive being passed between peers
+var peerErrors = []error{ErrCircuitOpen, ErrLookupUnavailable}
+
+// fromPeerError wraps an error from the owning peer with the lookup error in its message, so every node
+// handles it the same as the owner
+func fromPeerError(err error) error {
+	if !errors.Is(err, &groupcache.ErrRemoteCall{}) {
+		return err
+	}
+	for _, peerErr := range peerErrors {
+		if strings.Contains(err.Error(), peerErr.Error()) {
+			return fmt.Errorf("%w on peer: %w", peerErr, err)
+		}
+	}
+	return err
+}
+
+// cacheKey is the whole request, so resolutions are cached per key ID and the control plane
+// can make different decisions per credential
+func cacheKey(req VirtualBucketResolveReq) (string, error) {
+	return sonic.MarshalString(req)
+}
+
+func CloseCache(ctx context.Context) error {
+	if poolServer == nil {
+		return nil
+	}
+	return poolServer.Shutdown(ctx)
+}
@@ -0,0 +1,190 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/uber-go/tally/v4"
+)
+
+type resolveFunc func(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error)
+
+func (f resolveFunc) Resolve(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+	return f(ctx, req)
+}
+
+var (
+	testCachedResolverOnce sync.Once
+	testCachedResolver     *CachedResolver
+	// The resolver cached by testCachedResolver, and the handler of its other peer
+	testInnerResolve atomic.Value // resolveFunc
+	testPeerHandler  atomic.Value // http.HandlerFunc
+)
+
+// useTestCachedResolver returns a cached resolver with a fake peer, resolving with resolve and serving peer
+// requests with peer. groupcache only allows one pool per process, so the resolver is shared by every test.
+func useTestCachedResolver(t *testing.T, resolve resolveFunc, peer http.HandlerFunc) *CachedResolver {
+	t.Helper()
+	testInnerResolve.Store(resolve)
+	testPeerHandler.Store(peer)
+	testCachedResolverOnce.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		selfAddr := "http://" + listener.Addr().String()
+		listener.Close()
+		peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			testPeerHandler.Load().(http.HandlerFunc)(w, r)
+		}))
+
+		prevSelfAddr, prevPeers := utils.CacheSelfAddr, utils.CachePeers
+		defer func() {
+			utils.CacheSelfAddr, utils.CachePeers = prevSelfAddr, prevPeers
+		}()
+		utils.CacheSelfAddr, utils.CachePeers = selfAddr, []string{selfAddr, peerServer.URL}
+		testCachedResolver = NewCachedResolver(context.Background(), resolveFunc(func(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+			return testInnerResolve.Load().(resolveFunc)(ctx, req)
+		}), tally.NoopScope)
+	})
+	return testCachedResolver
+}
+
+// testVirtualBuckets returns n virtual buckets owned by the fake peer if remote, otherwise by the resolver
+func testVirtualBuckets(t *testing.T, r *CachedResolver, n int, remote bool) []string {
+	t.Helper()
+	var virtualBuckets []string
+	for i := 0; len(virtualBuckets) < n; i++ {
+		virtualBucket := fmt.Sprintf("%s-%d", t.Name(), i)
+		key, err := cacheKey(VirtualBucketResolveReq{VirtualBucket: virtualBucket, KeyID: "AKID"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, isRemote := r.pool.PickPeer(key); isRemote == remote {
+			virtualBuckets = append(virtualBuckets, virtualBucket)
+		}
+	}
+	return virtualBuckets
+}
+
+func (r *CachedResolver) trackedKeys() int {
+	r.keysMu.Lock()
+	defer r.keysMu.Unlock()
+	var n int
+	for _, bucketKeys := range r.keys {
+		n += len(bucketKeys)
+	}
+	return n
+}
+
+func TestCachedResolverPrunesKeys(t *testing.T) {
+	negativeSeconds := utils.CacheNegativeSeconds
+	t.Cleanup(func() {
+		utils.CacheNegativeSeconds = negativeSeconds
+	})
+	utils.CacheNegativeSeconds = 0
+	r := useTestCachedResolver(t, func(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+		return nil, ErrLookupNotFound
+	}, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "peer down", http.StatusServiceUnavailable)
+	})
+	ctx := context.Background()
+
+	// e.g. a client spraying random virtual bucket hostnames
+	for _, virtualBucket := range testVirtualBuckets(t, r, 50, false) {
+		if _, err := r.Resolve(ctx, VirtualBucketResolveReq{VirtualBucket: virtualBucket, KeyID: "AKID"}); !errors.Is(err, ErrLookupNotFound) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	}
+	// Failed resolutions have no local entry, so aren't tracked
+	for _, virtualBucket := range testVirtualBuckets(t, r, 5, true) {
+		if _, err := r.Resolve(ctx, VirtualBucketResolveReq{VirtualBucket: virtualBucket, KeyID: "AKID"}); err == nil {
+			t.Fatal("expected the peer to fail")
+		}
+	}
+	if tracked := r.trackedKeys(); tracked != 50 {
+		t.Fatalf("expected 50 tracked keys, got %d", tracked)
+	}
+
+	r.sweepLocalOnce(time.Now().Add(time.Second))
+	if tracked := r.trackedKeys(); tracked != 0 {
+		t.Fatalf("expected expired keys to be pruned, %d left", tracked)
+	}
+}
+
+func TestCacheEntryHasNoSecrets(t *testing.T) {
+	utils.UpstreamCredentials = "tenant-a:AKIDTENANTA:tenant-a-secret"
+	res := &VirtualBucketResolveRes{
+		Prefix:         "tenant-a",
+		CredentialsRef: utils.Ptr("tenant-a"),
+	}
+
+	// Only this node resolves the ref to the secret
+	target, err := res.StorageTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.AccessKeyID != "AKIDTENANTA" || target.SecretAccessKey != "tenant-a-secret" {
+		t.Fatalf("ref not resolved %+v", target)
+	}
+
+	// What groupcache stores and serves to peers
+	cached, err := sonic.Marshal(cacheEntry{Res: res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(cached), "tenant-a-secret") || strings.Contains(string(cached), "AKIDTENANTA") {
+		t.Fatalf("cached resolution has credentials: %s", cached)
+	}
+
+	res.CredentialsRef = utils.Ptr("unknown")
+	if _, err = res.StorageTarget(); err == nil {
+		t.Fatal("unknown ref resolved")
+	}
+}
+
+func TestCachedResolverPeerErrors(t *testing.T) {
+	useTestLookupAPI(t, http.StatusBadGateway)
+	owner := &HTTPResolver{}
+	// Fails like a real peer that owns the key, which only gets the message back to the caller
+	peer := func(w http.ResponseWriter, r *http.Request) {
+		_, err := owner.Resolve(r.Context(), VirtualBucketResolveReq{})
+		http.Error(w, fmt.Errorf("error in inner.Resolve: %w", err).Error(), http.StatusServiceUnavailable)
+	}
+	r := useTestCachedResolver(t, owner.Resolve, peer)
+
+	tests := []struct {
+		name     string
+		expected error
+	}{
+		{"retries exhausted", ErrLookupUnavailable},
+		{"circuit open", ErrCircuitOpen},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.expected == ErrCircuitOpen {
+				for apiBreaker.Allow() == nil {
+					apiBreaker.Failure()
+				}
+			}
+			for _, remote := range []bool{false, true} {
+				virtualBucket := testVirtualBuckets(t, r, 1, remote)[0]
+				_, err := r.Resolve(context.Background(), VirtualBucketResolveReq{VirtualBucket: virtualBucket, KeyID: "AKID"})
+				if !errors.Is(err, test.expected) {
+					t.Fatalf("remote owner %t: expected %v, got %v", remote, test.expected, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package lookup
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("lookup circuit breaker open")
+
+// circuitBreaker opens after `threshold` consecutive failures, rejecting calls for `cooldown`.
+// After the cooldown a single trial call is let through, closing the breaker if it succeeds.
+type circuitBreaker struct {
+	threshold int64
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int64
+	openUntil time.Time
+	trialing  bool
+}
+
+func newCircuitBreaker(threshold int64, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns ErrCircuitOpen if the call should not be made
+func (cb *circuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return nil
+	}
+	if time.Now().Before(cb.openUntil) || cb.trialing {
+		return ErrCircuitOpen
+	}
+	// Half open, let a single trial through
+	cb.trialing = true
+	return nil
+}
+
+func (cb *circuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.trialing = false
+}
+
+func (cb *circuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	cb.trialing = false
+	if cb.failures >= cb.threshold {
+		cb.openUntil = time.Now().Add(cb.cooldown)
+	}
+}
@@ -0,0 +1,31 @@
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"strconv"
+)
+
+// DevResolver resolves every virtual bucket to DEV_LOOKUP_PREFIX (and DEV_LOOKUP_TIME_MS if provided)
+type DevResolver struct {
+	res VirtualBucketResolveRes
+}
+
+func NewDevResolver() (*DevResolver, error) {
+	r := &DevResolver{}
+	r.res.Prefix = utils.DevLookupPrefix
+	if utils.DevLookupTimeMS != "" {
+		timeMS, err := strconv.Atoi(utils.DevLookupTimeMS)
+		if err != nil {
+			return nil, fmt.Errorf("error in Atoi(DevLookupTimeMS): %w", err)
+		}
+		r.res.TimeMS = utils.Ptr(int64(timeMS))
+	}
+	return r, nil
+}
+
+func (r *DevResolver) Resolve(context.Context, VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+	res := r.res
+	return &res, nil
+}
@@ -0,0 +1,113 @@
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileResolver resolves virtual buckets from a YAML or JSON file mapping virtual bucket names to resolutions, e.g.:
+//
+//	fakebucket:
+//	  Prefix: example
+//	  TimeMS: 1700000000000
+//
+// The file is polled every LOOKUP_FILE_POLL_SECONDS and reloaded when it changes.
+type FileResolver struct {
+	fileName string
+	modTime  time.Time
+	buckets  map[string]VirtualBucketResolveRes
+	mu       sync.RWMutex
+}
+
+func NewFileResolver(ctx context.Context, fileName string) (*FileResolver, error) {
+	r := &FileResolver{
+		fileName: fileName,
+	}
+	if err := r.load(); err != nil {
+		return nil, fmt.Errorf("error in load: %w", err)
+	}
+
+	go r.pollFile(ctx)
+	return r, nil
+}
+
+func (r *FileResolver) Resolve(_ context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res, exists := r.buckets[req.VirtualBucket]
+	if !exists {
+		return nil, fmt.Errorf("virtual bucket '%s' not in file: %w", req.VirtualBucket, ErrLookupNotFound)
+	}
+	return &res, nil
+}
+
+func (r *FileResolver) load() error {
+	stat, err := os.Stat(r.fileName)
+	if err != nil {
+		return fmt.Errorf("error in os.Stat: %w", err)
+	}
+	fileBytes, err := os.ReadFile(r.fileName)
+	if err != nil {
+		return fmt.Errorf("error in os.ReadFile: %w", err)
+	}
+
+	// YAML is a superset of JSON, round trip through JSON so the field names match the HTTP lookup
+	var raw map[string]any
+	err = yaml.Unmarshal(fileBytes, &raw)
+	if err != nil {
+		return fmt.Errorf("error in yaml.Unmarshal: %w", err)
+	}
+	jBytes, err := sonic.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("error in sonic.Marshal: %w", err)
+	}
+	buckets := map[string]VirtualBucketResolveRes{}
+	err = sonic.Unmarshal(jBytes, &buckets)
+	if err != nil {
+		return fmt.Errorf("error in sonic.Unmarshal: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buckets = buckets
+	r.modTime = stat.ModTime()
+	return nil
+}
+
+func (r *FileResolver) pollFile(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(time.Second * time.Duration(utils.LookupFilePollSeconds))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stat, err := os.Stat(r.fileName)
+		if err != nil {
+			logger.Error().Err(err).Str("file", r.fileName).Msg("error checking lookup file, keeping last loaded")
+			continue
+		}
+		r.mu.RLock()
+		changed := !stat.ModTime().Equal(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			logger.Error().Err(err).Str("file", r.fileName).Msg("error reloading lookup file, keeping last loaded")
+			continue
+		}
+		logger.Info().Str("file", r.fileName).Msg("reloaded lookup file")
+	}
+}
@@ -0,0 +1,163 @@
+package lookup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/UltimateTournament/backoff/v4"
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/rs/zerolog"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrNoLookupURL = errors.New("missing LOOKUP_URL")
+	// ErrLookupRejected is a 4xx other than 403 and 404, retrying the same request won't change it
+	ErrLookupRejected = errors.New("lookup rejected the request")
+	// ErrLookupUnavailable is returned once the retries are exhausted
+	ErrLookupUnavailable = errors.New("lookup unavailable")
+
+	apiBreaker = newCircuitBreaker(utils.LookupBreakerFailures, time.Second*time.Duration(utils.LookupBreakerCooldownSeconds))
+)
+
+// HTTPResolver asks the control plane at LOOKUP_URL to resolve virtual buckets
+type HTTPResolver struct{}
+
+func NewHTTPResolver() (*HTTPResolver, error) {
+	if utils.LookupURL == "" {
+		return nil, ErrNoLookupURL
+	}
+	return &HTTPResolver{}, nil
+}
+
+func (r *HTTPResolver) Resolve(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+	jBytes, err := sonic.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error in sonic.Marshal: %w", err)
+	}
+
+	resBytes, err := resolveFromAPI(ctx, "/resolve_virtual_bucket", jBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error in resolveFromAPI: %w", err)
+	}
+
+	var resBody VirtualBucketResolveRes
+	err = sonic.Unmarshal(resBytes, &resBody)
+	if err != nil {
+		return nil, fmt.Errorf("error in sonic.Unmarshal: %w", err)
+	}
+
+	return &resBody, nil
+}
+
+// resolveFromAPI calls the lookup API, retrying with backoff. Failures trip the circuit breaker, after which
+// calls fail fast with ErrCircuitOpen until the cooldown has passed. ErrLookupUnavailable is returned if
+// every retry failed.
+func resolveFromAPI(ctx context.Context, path string, body []byte) ([]byte, error) {
+	var resBytes []byte
+	var retryable bool
+	cfg := backoff.WithContext(backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(utils.LookupRetries)), ctx)
+	err := backoff.RetryNotify(func() error {
+		retryable = false
+		if err := apiBreaker.Allow(); err != nil {
+			return backoff.Permanent(err)
+		}
+
+		var err error
+		resBytes, err = doLookupRequest(ctx, path, body)
+		if errors.Is(err, ErrLookupNotFound) || errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrLookupRejected) {
+			// The API is healthy, it just said no
+			apiBreaker.Success()
+			return backoff.Permanent(err)
+		}
+		if ctx.Err() != nil {
+			// The caller gave up (canceled or its deadline passed), which says nothing about the API
+			return backoff.Permanent(err)
+		}
+		if err != nil {
+			apiBreaker.Failure()
+			retryable = true
+			return err
+		}
+
+		apiBreaker.Success()
+		return nil
+	}, cfg, func(err error, d time.Duration) {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("path", path).Dur("backoff", d).Msg("lookup request failed, retrying")
+	})
+	if err != nil && retryable && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w: %w", ErrLookupUnavailable, err)
+	}
+	return resBytes, err
+}
+
+func doLookupRequest(ctx context.Context, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", utils.LookupURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error in NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+	if utils.LookupAuth != "" {
+		req.Header.Set("Authorization", utils.LookupAuth)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error in http.Do: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrLookupNotFound
+	}
+	if res.StatusCode == http.StatusForbidden {
+		return nil, ErrAccessDenied
+	}
+
+	resBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error in io.ReadAll: %w", err)
+	}
+
+	if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests {
+		return nil, fmt.Errorf("status code %d from lookup: %s: %w", res.StatusCode, string(resBytes), ErrLookupRejected)
+	}
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("high status code %d from lookup: %s", res.StatusCode, string(resBytes))
+	}
+
+	return resBytes, nil
+}
+
+// ResolveKeySecrets looks up the currently valid secrets for an access key ID.
+// The remote server should return a 404 or an empty list of secrets for unknown keys.
+func ResolveKeySecrets(ctx context.Context, keyID string) ([]string, error) {
+	if utils.LookupURL == "" {
+		return nil, ErrNoLookupURL
+	}
+
+	jBytes, err := sonic.Marshal(KeySecretsReq{
+		KeyID: keyID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in sonic.Marshal: %w", err)
+	}
+
+	resBytes, err := resolveFromAPI(ctx, "/resolve_key", jBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error in resolveFromAPI: %w", err)
+	}
+
+	var resBody KeySecretsRes
+	err = sonic.Unmarshal(resBytes, &resBody)
+	if err != nil {
+		return nil, fmt.Errorf("error in sonic.Unmarshal: %w", err)
+	}
+
+	return resBody.Secrets, nil
+}
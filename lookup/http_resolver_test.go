@@ -0,0 +1,66 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+// useTestLookupAPI points the lookup at a server that always responds with status, and counts its requests
+func useTestLookupAPI(t *testing.T, status int) *atomic.Int64 {
+	t.Helper()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	lookupURL, retries, breaker := utils.LookupURL, utils.LookupRetries, apiBreaker
+	t.Cleanup(func() {
+		utils.LookupURL, utils.LookupRetries, apiBreaker = lookupURL, retries, breaker
+	})
+	utils.LookupURL, utils.LookupRetries = server.URL, 1
+	apiBreaker = newCircuitBreaker(5, time.Minute)
+	return &requests
+}
+
+func TestResolveFromAPIRejected(t *testing.T) {
+	requests := useTestLookupAPI(t, http.StatusBadRequest)
+	_, err := resolveFromAPI(context.Background(), "/resolve_key", nil)
+	if !errors.Is(err, ErrLookupRejected) {
+		t.Fatalf("expected rejected, got %v", err)
+	}
+	if requests.Load() != 1 || apiBreaker.failures != 0 {
+		t.Fatalf("rejection was retried %d times and counted %d failures", requests.Load(), apiBreaker.failures)
+	}
+}
+
+func TestResolveFromAPIServerError(t *testing.T) {
+	requests := useTestLookupAPI(t, http.StatusBadGateway)
+	if _, err := resolveFromAPI(context.Background(), "/resolve_key", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	if requests.Load() != 2 || apiBreaker.failures != 2 {
+		t.Fatalf("expected a retry and 2 failures, got %d requests and %d failures", requests.Load(), apiBreaker.failures)
+	}
+}
+
+func TestResolveFromAPICallerDeadline(t *testing.T) {
+	useTestLookupAPI(t, http.StatusOK)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := resolveFromAPI(ctx, "/resolve_key", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if apiBreaker.failures != 0 {
+		t.Fatalf("caller's deadline counted %d failures", apiBreaker.failures)
+	}
+}
@@ -0,0 +1,150 @@
+package lookup
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/rs/zerolog"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const peerInvalidatePath = "/_icedb/invalidate"
+
+var (
+	ErrCacheNotEnabled      = errors.New("cache not enabled")
+	ErrInvalidationNoTarget = errors.New("must provide a virtual bucket or all")
+)
+
+type InvalidateReq struct {
+	// Evicts all resolutions (every key ID) of the virtual bucket
+	VirtualBucket string
+	// Evicts every virtual bucket
+	All bool
+}
+
+// CheckInvalidateAuth compares the provided auth to CACHE_INVALIDATE_AUTH. If it is not set, invalidation is disabled.
+func CheckInvalidateAuth(auth string) bool {
+	if utils.CacheInvalidateAuth == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth), []byte(utils.CacheInvalidateAuth)) == 1
+}
+
+// Invalidate evicts cached resolutions on this node and tells every other peer to do the same
+func Invalidate(ctx context.Context, req InvalidateReq) error {
+	if req.VirtualBucket == "" && !req.All {
+		return ErrInvalidationNoTarget
+	}
+	cachedResolver, ok := resolver.(*CachedResolver)
+	if !ok {
+		return ErrCacheNotEnabled
+	}
+
+	cachedResolver.invalidateLocal(ctx, req)
+	return cachedResolver.invalidatePeers(ctx, req)
+}
+
+// invalidateLocal evicts every key we know about for the request. Removing from the group also
+// removes it from the owner and the hot cache of every peer.
+func (r *CachedResolver) invalidateLocal(ctx context.Context, req InvalidateReq) {
+	logger := zerolog.Ctx(ctx)
+	r.keysMu.Lock()
+	var keys []string
+	for virtualBucket, bucketKeys := range r.keys {
+		if !req.All && virtualBucket != req.VirtualBucket {
+			continue
+		}
+		for key := range bucketKeys {
+			keys = append(keys, key)
+		}
+		delete(r.keys, virtualBucket)
+	}
+	r.keysMu.Unlock()
+
+	for _, key := range keys {
+		r.local.Delete(key)
+		if err := r.group.Remove(ctx, key); err != nil {
+			logger.Warn().Err(err).Str("key", key).Msg("error removing key from groupcache peers")
+		}
+	}
+	logger.Info().Str("virtualBucket", req.VirtualBucket).Bool("all", req.All).Int("keys", len(keys)).Msg("invalidated cached resolutions")
+}
+
+func (r *CachedResolver) invalidatePeers(ctx context.Context, req InvalidateReq) error {
+	jBytes, err := sonic.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("error in sonic.Marshal: %w", err)
+	}
+
+	r.peersMu.RLock()
+	peers := r.peers
+	r.peersMu.RUnlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(peers))
+	for i, peer := range peers {
+		if peer == utils.CacheSelfAddr {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			errs[i] = sendPeerInvalidate(ctx, peer, jBytes)
+		}(i, peer)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func sendPeerInvalidate(ctx context.Context, peer string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+peerInvalidatePath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error in NewRequestWithContext for peer %s: %w", peer, err)
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("Authorization", utils.CacheInvalidateAuth)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error in http.Do for peer %s: %w", peer, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		resBytes, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("high status code %d from peer %s: %s", res.StatusCode, peer, string(resBytes))
+	}
+	return nil
+}
+
+// handlePeerInvalidate is called by other peers, only invalidating locally so it doesn't fan out again
+func (r *CachedResolver) handlePeerInvalidate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !CheckInvalidateAuth(req.Header.Get("Authorization")) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var invalidateReq InvalidateReq
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err == nil {
+		err = sonic.Unmarshal(bodyBytes, &invalidateReq)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	r.invalidateLocal(req.Context(), invalidateReq)
+	w.WriteHeader(http.StatusOK)
+}
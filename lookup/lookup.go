@@ -1,25 +1,23 @@
 package lookup
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/storage"
 	"github.com/danthegoodman1/GoAPITemplate/utils"
-	"github.com/mailgun/groupcache/v2"
-	"github.com/rs/zerolog"
-	"io"
-	"net/http"
-	"strconv"
-	"strings"
+	"github.com/uber-go/tally/v4"
 	"time"
 )
 
 var (
-	ErrNoPathPrefix = errors.New("no path prefix for virtual bucket")
-	poolServer      *http.Server
-	group           *groupcache.Group
+	ErrNoPathPrefix         = errors.New("no path prefix for virtual bucket")
+	ErrAccessDenied         = errors.New("lookup denied access for key")
+	ErrLookupNotFound       = errors.New("lookup returned not found")
+	ErrUnknownLookupBackend = errors.New("unknown lookup backend")
+	ErrAsOfTooOld           = errors.New("as of time is before the earliest allowed")
+
+	resolver Resolver
 )
 
 type (
@@ -31,124 +29,123 @@ type (
 		Prefix string
 		// If omitted, will be current time
 		TimeMS *int64
+		// The earliest snapshot time the key may request with time travel. If omitted, there is no limit.
+		MinTimeMS *int64
+		// If omitted, will be S3_BUCKET
+		Bucket *string
+		// If omitted, will be S3_URL
+		Endpoint *string
+		// If omitted, will be AWS_REGION
+		Region *string
+		// If omitted, will be S3_USE_PATH
+		UsePathStyle *bool
+		// If omitted, will be SCHEMA_POLICY
+		SchemaPolicy *string
+		// Names the credentials for the real bucket in UPSTREAM_CREDENTIALS, which each node resolves locally so
+		// secrets are never cached or shared between peers. If omitted, will be AWS_KEY_ID and AWS_KEY_SECRET
+		CredentialsRef *string
 	}
-)
 
-func InitCache(ctx context.Context) {
-	logger := zerolog.Ctx(ctx)
-	pool := groupcache.NewHTTPPoolOpts(utils.CacheSelfAddr, &groupcache.HTTPPoolOptions{})
-
-	// Add more peers to the cluster You MUST Ensure our instance is included in this list else
-	// determining who owns the key across the cluster will not be consistent, and the pool won't
-	// be able to determine if our instance owns the key.
-	pool.Set(utils.CachePeers...)
+	KeySecretsReq struct {
+		KeyID string
+	}
+	KeySecretsRes struct {
+		// All currently valid secrets for the key, multiple are allowed for rotation
+		Secrets []string
+	}
 
-	poolServer = &http.Server{
-		Addr:    strings.Split(utils.CacheSelfAddr, "://")[1],
-		Handler: pool,
+	// Resolver turns a virtual bucket into a prefix (and optionally where it lives) for a key ID.
+	// Unknown virtual buckets should return ErrLookupNotFound, and keys that may not access the
+	// virtual bucket should return ErrAccessDenied.
+	Resolver interface {
+		Resolve(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error)
 	}
+)
 
-	// Start an HTTP server to listen for peer requests from the groupcache
-	go func() {
-		logger.Debug().Msg("cache pool server listening...")
-		if err := poolServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Error().Err(err).Msg("error on pool server listen")
+// StorageTarget is the real bucket for the virtual bucket, falling back to the environment config for omitted fields
+func (r *VirtualBucketResolveRes) StorageTarget() (storage.Target, error) {
+	target := storage.DefaultTarget()
+	target.Bucket = utils.Deref(r.Bucket, target.Bucket)
+	target.Endpoint = utils.Deref(r.Endpoint, target.Endpoint)
+	target.Region = utils.Deref(r.Region, target.Region)
+	target.UsePathStyle = utils.Deref(r.UsePathStyle, target.UsePathStyle)
+	if r.CredentialsRef != nil {
+		creds, err := storage.ResolveCredentialsRef(*r.CredentialsRef)
+		if err != nil {
+			return storage.Target{}, fmt.Errorf("error in storage.ResolveCredentialsRef: %w", err)
 		}
-	}()
-
-	group = groupcache.NewGroup("virtual_buckets", 3000000, groupcache.GetterFunc(
-		func(ctx context.Context, virtualBucket string, dest groupcache.Sink) error {
-			jBytes, err := sonic.Marshal(VirtualBucketResolveReq{
-				VirtualBucket: virtualBucket,
-			})
-			if err != nil {
-				return fmt.Errorf("error in sonic.Marshal: %w", err)
-			}
-
-			res, err := resolveFromAPI(ctx, jBytes)
-			if err != nil {
-				return fmt.Errorf("error in resolveFromAPI: %w", err)
-			}
+		target.AccessKeyID = creds.AccessKeyID
+		target.SecretAccessKey = creds.SecretAccessKey
+	}
+	return target, nil
+}
 
-			return dest.SetBytes(res, time.Now().Add(time.Second*time.Duration(utils.CacheTTLSeconds)))
-		},
-	))
+// SnapshotTimeMS is the time of the IceDB snapshot to read, which is now if TimeMS is omitted or 0
+func (r *VirtualBucketResolveRes) SnapshotTimeMS() int64 {
+	if timeMS := utils.Deref(r.TimeMS, 0); timeMS != 0 {
+		return timeMS
+	}
+	return time.Now().UnixMilli()
 }
 
-func CloseCache(ctx context.Context) error {
-	return poolServer.Shutdown(ctx)
+// SchemaPolicyName is the schema evolution policy for the virtual bucket, falling back to SCHEMA_POLICY
+func (r *VirtualBucketResolveRes) SchemaPolicyName() string {
+	return utils.Deref(r.SchemaPolicy, utils.SchemaPolicy)
 }
 
-func resolveFromAPI(ctx context.Context, body []byte) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", utils.LookupURL+"/resolve_virtual_bucket", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("error in NewRequestWithContext: %w", err)
+// SnapshotTimeAsOf is the time of the IceDB snapshot to read for a client requested as of time (0 if not
+// requested). It can't be before MinTimeMS, and is capped at a pinned TimeMS.
+func (r *VirtualBucketResolveRes) SnapshotTimeAsOf(asOfMS int64) (int64, error) {
+	snapshotTimeMS := r.SnapshotTimeMS()
+	if asOfMS == 0 {
+		return snapshotTimeMS, nil
 	}
-	req.Header.Set("content-type", "application/json")
-	if utils.LookupAuth != "" {
-		req.Header.Set("Authorization", utils.LookupAuth)
+	if minTimeMS := utils.Deref(r.MinTimeMS, 0); asOfMS < minTimeMS {
+		return 0, fmt.Errorf("as of %d with min %d: %w", asOfMS, minTimeMS, ErrAsOfTooOld)
 	}
+	return min(asOfMS, snapshotTimeMS), nil
+}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error in http.Do: %w", err)
+// InitResolver creates the resolver selected by LOOKUP_BACKEND, wrapping it in the cache if enabled.
+// The cache reports its metrics to scope.
+func InitResolver(ctx context.Context, scope tally.Scope) error {
+	var err error
+	switch {
+	case utils.DevLookupPrefix != "":
+		resolver, err = NewDevResolver()
+	case utils.LookupBackend == "http":
+		resolver, err = NewHTTPResolver()
+	case utils.LookupBackend == "file":
+		resolver, err = NewFileResolver(ctx, utils.LookupFile)
+	case utils.LookupBackend == "sql":
+		resolver, err = NewSQLResolver(ctx, utils.LookupDSN)
+	default:
+		return fmt.Errorf("backend '%s': %w", utils.LookupBackend, ErrUnknownLookupBackend)
 	}
-
-	defer res.Body.Close()
-
-	resBytes, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error in io.ReadAll: %w", err)
+		return fmt.Errorf("error creating %s resolver: %w", utils.LookupBackend, err)
 	}
 
-	return resBytes, nil
+	if utils.CacheEnabled && utils.DevLookupPrefix == "" {
+		resolver = NewCachedResolver(ctx, resolver, scope)
+	}
+	return nil
 }
 
 // ResolveVirtualBucket Lookups up a prefix (namespace) and timestamp for a given virtual bucket.
-// The remote server must return a prefix. If a timestamp is not returned then the current one will be used.
+// The resolver must return a prefix. If a timestamp is not returned then the current one will be used.
 func ResolveVirtualBucket(ctx context.Context, virtBucket, keyID string) (*VirtualBucketResolveRes, error) {
-	var resBody VirtualBucketResolveRes
-	if utils.DevLookupPrefix != "" {
-		resBody.Prefix = utils.DevLookupPrefix
-		if utils.DevLookupTimeMS != "" {
-			timeMS, err := strconv.Atoi(utils.DevLookupTimeMS)
-			if err != nil {
-				return nil, fmt.Errorf("error in Atoi(DevLookupTimeMS): %w", err)
-			}
-			resBody.TimeMS = utils.Ptr(int64(timeMS))
-		}
-		return &resBody, nil
-	}
-
-	var jBytes, resBytes []byte
-	var err error
-	if utils.CacheEnabled {
-		if err := group.Get(ctx, virtBucket, groupcache.AllocatingByteSliceSink(&resBytes)); err != nil {
-			return nil, fmt.Errorf("error getting from groupcache: %w", err)
-		}
-	} else {
-		jBytes, err = sonic.Marshal(VirtualBucketResolveReq{
-			VirtualBucket: virtBucket,
-			KeyID:         keyID,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("error in sonic.Marshal: %w", err)
-		}
-
-		resBytes, err = resolveFromAPI(ctx, jBytes)
-		if err != nil {
-			return nil, fmt.Errorf("error in resolveFromAPI: %w", err)
-		}
-
-	}
-	err = sonic.Unmarshal(resBytes, &resBody)
+	resBody, err := resolver.Resolve(ctx, VirtualBucketResolveReq{
+		VirtualBucket: virtBucket,
+		KeyID:         keyID,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error in sonic.Unmarshal: %w", err)
+		return nil, fmt.Errorf("error in resolver.Resolve: %w", err)
 	}
 
 	if resBody.Prefix == "" {
 		return nil, fmt.Errorf("virtual bucket '%s': %w", virtBucket, ErrNoPathPrefix)
 	}
 
-	return &resBody, nil
+	return resBody, nil
 }
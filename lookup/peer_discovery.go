@@ -0,0 +1,155 @@
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/rs/zerolog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Overridden in tests
+var (
+	lookupHost = net.DefaultResolver.LookupHost
+	lookupSRV  = net.DefaultResolver.LookupSRV
+)
+
+// discoverPeers periodically resolves CACHE_PEER_DNS or reads CACHE_PEER_FILE, updating the peers when membership changes
+func (r *CachedResolver) discoverPeers(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(time.Second * time.Duration(utils.CachePeerRefreshSeconds))
+	defer ticker.Stop()
+	for {
+		peers, err := findPeers(ctx)
+		if err != nil {
+			r.metrics.discoveryErrors.Inc(1)
+			logger.Error().Err(err).Msg("error discovering cache peers, keeping current peers")
+		} else {
+			r.peersMu.RLock()
+			changed := !slices.Equal(peers, r.peers)
+			r.peersMu.RUnlock()
+			if changed {
+				logger.Info().Strs("peers", peers).Msg("cache peer membership changed")
+				r.metrics.membershipChanges.Inc(1)
+				r.SetPeers(peers...)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// findPeers returns the sorted peers, always including self. Discovered peers that are this node are replaced
+// with CACHE_SELF_ADDR, as that is how the pool knows itself, and every node must set the same peers.
+func findPeers(ctx context.Context) ([]string, error) {
+	var peers []string
+	var err error
+	switch {
+	case utils.CachePeerFile != "":
+		peers, err = peersFromFile(utils.CachePeerFile)
+	case strings.HasPrefix(utils.CachePeerDNS, "_"):
+		peers, err = peersFromSRV(ctx, utils.CachePeerDNS)
+	default:
+		peers, err = peersFromA(ctx, utils.CachePeerDNS)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(peers, utils.CacheSelfAddr) {
+		peers = append(peers, utils.CacheSelfAddr)
+	}
+	slices.Sort(peers)
+	return peers, nil
+}
+
+// peersFromFile reads peers separated by commas or newlines
+func peersFromFile(fileName string) ([]string, error) {
+	fileBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error in os.ReadFile: %w", err)
+	}
+	var peers []string
+	for _, peer := range strings.FieldsFunc(string(fileBytes), func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+// peersFromSRV resolves SRV records (e.g. `_groupcache._tcp.my-svc.my-ns.svc.cluster.local` for a named port of a
+// headless service), using the port from each record
+func peersFromSRV(ctx context.Context, name string) ([]string, error) {
+	selfURL, err := url.Parse(utils.CacheSelfAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error in url.Parse(CacheSelfAddr): %w", err)
+	}
+	_, records, err := lookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("error in LookupSRV: %w", err)
+	}
+	var peers []string
+	for _, record := range records {
+		target, port := strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port)
+		if port == selfURL.Port() && isSelfTarget(target, selfURL.Hostname()) {
+			peers = append(peers, utils.CacheSelfAddr)
+			continue
+		}
+		peers = append(peers, peerURL(target, port))
+	}
+	return peers, nil
+}
+
+// isSelfTarget is whether the SRV target is the self host, which may be the pod's short hostname
+// (e.g. `pod-0` for `pod-0.my-svc.my-ns.svc.cluster.local`)
+func isSelfTarget(target, selfHost string) bool {
+	if strings.EqualFold(target, selfHost) {
+		return true
+	}
+	shortTarget, _, _ := strings.Cut(target, ".")
+	return !strings.Contains(selfHost, ".") && strings.EqualFold(shortTarget, selfHost)
+}
+
+// peersFromA resolves the A/AAAA records of a headless service, using the port of CACHE_SELF_ADDR
+func peersFromA(ctx context.Context, name string) ([]string, error) {
+	selfURL, err := url.Parse(utils.CacheSelfAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error in url.Parse(CacheSelfAddr): %w", err)
+	}
+	// The records are IPs, so self is matched by the IPs of its host
+	selfAddrs, err := lookupHost(ctx, selfURL.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("error in LookupHost(CacheSelfAddr): %w", err)
+	}
+	addrs, err := lookupHost(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error in LookupHost: %w", err)
+	}
+	var peers []string
+	for _, addr := range addrs {
+		if slices.ContainsFunc(selfAddrs, func(selfAddr string) bool {
+			return net.ParseIP(selfAddr).Equal(net.ParseIP(addr))
+		}) {
+			peers = append(peers, utils.CacheSelfAddr)
+			continue
+		}
+		peers = append(peers, peerURL(addr, selfURL.Port()))
+	}
+	return peers, nil
+}
+
+func peerURL(host, port string) string {
+	return "http://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,86 @@
+package lookup
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+// useTestDNS resolves hosts and SRV records from the maps, and sets CACHE_SELF_ADDR and CACHE_PEER_DNS
+func useTestDNS(t *testing.T, selfAddr, peerDNS string, hosts map[string][]string, srvs map[string][]*net.SRV) {
+	t.Helper()
+	prevLookupHost, prevLookupSRV := lookupHost, lookupSRV
+	prevSelfAddr, prevPeerDNS, prevPeerFile := utils.CacheSelfAddr, utils.CachePeerDNS, utils.CachePeerFile
+	t.Cleanup(func() {
+		lookupHost, lookupSRV = prevLookupHost, prevLookupSRV
+		utils.CacheSelfAddr, utils.CachePeerDNS, utils.CachePeerFile = prevSelfAddr, prevPeerDNS, prevPeerFile
+	})
+	lookupHost = func(_ context.Context, host string) ([]string, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []string{host}, nil
+		}
+		addrs, exists := hosts[host]
+		if !exists {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return addrs, nil
+	}
+	lookupSRV = func(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+		return name, srvs[name], nil
+	}
+	utils.CacheSelfAddr, utils.CachePeerDNS, utils.CachePeerFile = selfAddr, peerDNS, ""
+}
+
+func TestFindPeersA(t *testing.T) {
+	hosts := map[string][]string{
+		"my-svc": {"10.0.0.1", "10.0.0.2"},
+		"pod-1":  {"10.0.0.2"},
+	}
+	for _, selfAddr := range []string{"http://pod-1:8090", "http://10.0.0.2:8090"} {
+		useTestDNS(t, selfAddr, "my-svc", hosts, nil)
+		peers, err := findPeers(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"http://10.0.0.1:8090", selfAddr}
+		slices.Sort(expected)
+		if !slices.Equal(peers, expected) {
+			t.Fatalf("self %s discovered as %v", selfAddr, peers)
+		}
+	}
+}
+
+func TestFindPeersSRV(t *testing.T) {
+	name := "_groupcache._tcp.my-svc.my-ns.svc.cluster.local"
+	srvs := map[string][]*net.SRV{
+		name: {
+			{Target: "pod-0.my-svc.my-ns.svc.cluster.local.", Port: 8090},
+			{Target: "pod-1.my-svc.my-ns.svc.cluster.local.", Port: 8090},
+		},
+	}
+	for _, selfAddr := range []string{"http://pod-1:8090", "http://pod-1.my-svc.my-ns.svc.cluster.local:8090"} {
+		useTestDNS(t, selfAddr, name, nil, srvs)
+		peers, err := findPeers(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"http://pod-0.my-svc.my-ns.svc.cluster.local:8090", selfAddr}
+		slices.Sort(expected)
+		if !slices.Equal(peers, expected) {
+			t.Fatalf("self %s discovered as %v", selfAddr, peers)
+		}
+	}
+
+	// Another port on the same host is a different peer
+	useTestDNS(t, "http://pod-1:9090", name, nil, srvs)
+	peers, err := findPeers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 3 {
+		t.Fatalf("expected self to be added, got %v", peers)
+	}
+}
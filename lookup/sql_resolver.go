@@ -0,0 +1,44 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+// SQLResolver resolves virtual buckets from the virtual_buckets table in Postgres/CockroachDB, see migrations/
+type SQLResolver struct {
+	pool *pgxpool.Pool
+}
+
+func NewSQLResolver(ctx context.Context, dsn string) (*SQLResolver, error) {
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error in pgxpool.Connect: %w", err)
+	}
+	return &SQLResolver{
+		pool: pool,
+	}, nil
+}
+
+func (r *SQLResolver) Resolve(ctx context.Context, req VirtualBucketResolveReq) (*VirtualBucketResolveRes, error) {
+	var res VirtualBucketResolveRes
+	err := utils.ReliableExec(ctx, r.pool, time.Second*5, func(ctx context.Context, conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT prefix, time_ms, min_time_ms, bucket, endpoint, region, use_path_style, schema_policy, credentials_ref
+			FROM virtual_buckets
+			WHERE name = $1
+		`, req.VirtualBucket).Scan(&res.Prefix, &res.TimeMS, &res.MinTimeMS, &res.Bucket, &res.Endpoint, &res.Region, &res.UsePathStyle, &res.SchemaPolicy, &res.CredentialsRef)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("virtual bucket '%s' not in table: %w", req.VirtualBucket, ErrLookupNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in ReliableExec: %w", err)
+	}
+	return &res, nil
+}
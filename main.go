@@ -39,13 +39,18 @@ func main() {
 		}
 	}()
 
-	httpServer := http_server.StartHTTPServer()
+	scope, scopeCloser := observability.NewRootScope(prometheusReporter)
+	defer scopeCloser.Close()
 
-	// Setup cache if we need
-	if utils.CacheEnabled {
-		lookup.InitCache(context.Background())
+	// Setup the resolver (and cache if we need)
+	err := lookup.InitResolver(context.Background(), scope)
+	if err != nil {
+		logger.Error().Err(err).Msg("error initializing lookup resolver, exiting")
+		os.Exit(1)
 	}
 
+	httpServer := http_server.StartHTTPServer()
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
@@ -70,7 +75,7 @@ func main() {
 	if utils.CacheEnabled {
 		ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
 		defer cancel()
-		err := lookup.CloseCache(ctx)
+		err = lookup.CloseCache(ctx)
 		if err != nil {
 			logger.Error().Err(err).Msg("failed to close cache")
 		}
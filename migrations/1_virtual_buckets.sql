@@ -0,0 +1,15 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS virtual_buckets (
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  time_ms INT8,
+  bucket TEXT,
+  endpoint TEXT,
+  region TEXT,
+  use_path_style BOOL,
+
+  PRIMARY KEY(name)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS virtual_buckets;
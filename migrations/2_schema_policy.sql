@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE virtual_buckets ADD COLUMN IF NOT EXISTS schema_policy TEXT;
+
+-- +migrate Down
+ALTER TABLE virtual_buckets DROP COLUMN IF EXISTS schema_policy;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE virtual_buckets ADD COLUMN IF NOT EXISTS min_time_ms INT8;
+
+-- +migrate Down
+ALTER TABLE virtual_buckets DROP COLUMN IF EXISTS min_time_ms;
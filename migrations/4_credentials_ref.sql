@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE virtual_buckets ADD COLUMN IF NOT EXISTS credentials_ref TEXT;
+
+-- +migrate Down
+ALTER TABLE virtual_buckets DROP COLUMN IF EXISTS credentials_ref;
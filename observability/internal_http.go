@@ -1,13 +1,18 @@
 package observability
 
 import (
+	"errors"
 	"github.com/danthegoodman1/GoAPITemplate/gologger"
+	"github.com/danthegoodman1/GoAPITemplate/lookup"
+	"io"
 	"log"
 	"net/http"
 	httppprof "net/http/pprof"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/uber-go/tally/v4"
 	"github.com/uber-go/tally/v4/prometheus"
 )
 
@@ -19,6 +24,7 @@ func StartInternalHTTPServer(address string, prom prometheus.Reporter) error {
 	server.HidePort = true
 	logger.Info().Str("address", address).Msg("Starting Internal API")
 	server.GET("/metrics", echo.WrapHandler(prom.HTTPHandler()))
+	server.POST("/cache/invalidate", invalidateCache)
 	server.Any("/debug/pprof/*", echo.WrapHandler(http.HandlerFunc(httppprof.Index)))
 	server.Any("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(httppprof.Cmdline)))
 	server.Any("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(httppprof.Profile)))
@@ -27,6 +33,31 @@ func StartInternalHTTPServer(address string, prom prometheus.Reporter) error {
 	return server.Start(address)
 }
 
+// invalidateCache evicts a virtual bucket (or all of them) from the lookup cache on every peer,
+// e.g. called by a control plane webhook.
+func invalidateCache(c echo.Context) error {
+	if !lookup.CheckInvalidateAuth(c.Request().Header.Get("Authorization")) {
+		return c.String(http.StatusForbidden, "forbidden")
+	}
+
+	var req lookup.InvalidateReq
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	err := lookup.Invalidate(c.Request().Context(), req)
+	if errors.Is(err, lookup.ErrInvalidationNoTarget) || errors.Is(err, lookup.ErrCacheNotEnabled) {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		// Invalidated locally, but some peers may have missed it
+		logger.Error().Err(err).Msg("error invalidating cache on peers")
+		return c.String(http.StatusBadGateway, err.Error())
+	}
+
+	return c.String(http.StatusOK, "ok")
+}
+
 func NewPrometheusReporter() prometheus.Reporter {
 	c := prometheus.Configuration{
 		TimerType: "histogram",
@@ -44,3 +75,12 @@ func NewPrometheusReporter() prometheus.Reporter {
 	}
 	return reporter
 }
+
+// NewRootScope reports metrics prefixed with `icedb_` to the prometheus reporter, the closer flushes them
+func NewRootScope(reporter prometheus.Reporter) (tally.Scope, io.Closer) {
+	return tally.NewRootScope(tally.ScopeOptions{
+		Prefix:         "icedb",
+		CachedReporter: reporter,
+		Separator:      prometheus.DefaultSeparator,
+	}, time.Second)
+}
@@ -0,0 +1,282 @@
+package sigv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Payload hashes of aws-chunked bodies
+const (
+	// StreamingPayload signs each chunk, chained from the seed signature of the request
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// StreamingPayloadTrailer signs each chunk and the trailing checksum
+	StreamingPayloadTrailer = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	// StreamingUnsignedPayloadTrailer only has a trailing checksum
+	StreamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	chunkAlgorithm   = "AWS4-HMAC-SHA256-PAYLOAD"
+	trailerAlgorithm = "AWS4-HMAC-SHA256-TRAILER"
+	trailerSignature = "x-amz-trailer-signature"
+	// maxChunkSize bounds how much of a chunk is buffered before its signature is checked
+	maxChunkSize = 16 << 20
+	// maxChunkLine bounds chunk header and trailer lines
+	maxChunkLine = 4096
+)
+
+var (
+	ErrMalformedChunk             = errors.New("malformed aws-chunked body")
+	ErrChunkSignatureDoesNotMatch = errors.New("chunk signature does not match")
+	ErrChecksumMismatch           = errors.New("trailing checksum does not match")
+	ErrDecodedLengthMismatch      = errors.New("body does not match x-amz-decoded-content-length")
+)
+
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// checksums are the trailing checksums that can be verified
+var checksums = map[string]func() hash.Hash{
+	"x-amz-checksum-crc32": func() hash.Hash {
+		return crc32.NewIEEE()
+	},
+	"x-amz-checksum-crc32c": func() hash.Hash {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	},
+	"x-amz-checksum-crc64nvme": func() hash.Hash {
+		return crc64.New(crc64NVMETable)
+	},
+	"x-amz-checksum-sha1":   sha1.New,
+	"x-amz-checksum-sha256": sha256.New,
+}
+
+type chunkedReader struct {
+	r          *bufio.Reader
+	body       io.Closer
+	auth       *Auth
+	signingKey []byte
+	signed     bool
+	// The trailer the client declared in x-amz-trailer, empty if there is none
+	trailer  string
+	checksum hash.Hash
+	// The previous signature in the chain, starting with the seed signature
+	prevSignature string
+	// -1 if x-amz-decoded-content-length was not sent
+	decodedLength int64
+	read          int64
+	// The verified bytes of the current chunk that haven't been read yet
+	chunk []byte
+	err   error
+}
+
+// IsStreaming returns whether the payload hash is one of the aws-chunked payloads
+func IsStreaming(payloadHash string) bool {
+	return payloadHash == StreamingPayload || payloadHash == StreamingPayloadTrailer || payloadHash == StreamingUnsignedPayloadTrailer
+}
+
+// ChunkedReader decodes the aws-chunked body of a request. Each chunk is verified with the signing key from
+// Verify before any of its bytes are returned, and the trailing checksum is checked at the end of the body.
+func (a *Auth) ChunkedReader(r *http.Request, signingKey []byte) (io.ReadCloser, error) {
+	if !IsStreaming(a.PayloadHash) {
+		return nil, fmt.Errorf("payload '%s' is not streaming: %w", a.PayloadHash, ErrMalformedChunk)
+	}
+	cr := &chunkedReader{
+		r:             bufio.NewReaderSize(r.Body, maxChunkLine),
+		body:          r.Body,
+		auth:          a,
+		signingKey:    signingKey,
+		signed:        a.PayloadHash != StreamingUnsignedPayloadTrailer,
+		prevSignature: a.Signature,
+		decodedLength: -1,
+	}
+
+	if a.PayloadHash != StreamingPayload {
+		cr.trailer = strings.ToLower(strings.TrimSpace(r.Header.Get("X-Amz-Trailer")))
+		newChecksum, exists := checksums[cr.trailer]
+		if !exists {
+			return nil, fmt.Errorf("unsupported x-amz-trailer '%s': %w", cr.trailer, ErrMalformedChunk)
+		}
+		cr.checksum = newChecksum()
+	}
+
+	if decodedLength := r.Header.Get("X-Amz-Decoded-Content-Length"); decodedLength != "" {
+		var err error
+		cr.decodedLength, err = strconv.ParseInt(decodedLength, 10, 64)
+		if err != nil || cr.decodedLength < 0 {
+			return nil, fmt.Errorf("invalid x-amz-decoded-content-length: %w", ErrMalformedChunk)
+		}
+	}
+	return cr, nil
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for len(cr.chunk) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		cr.err = cr.readChunk()
+	}
+	n := copy(p, cr.chunk)
+	cr.chunk = cr.chunk[n:]
+	return n, nil
+}
+
+func (cr *chunkedReader) Close() error {
+	return cr.body.Close()
+}
+
+// readChunk reads and verifies the next chunk, returning io.EOF after the final chunk
+func (cr *chunkedReader) readChunk() error {
+	line, err := cr.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("invalid chunk size '%s': %w", sizeHex, ErrMalformedChunk)
+	}
+	signature, found := strings.CutPrefix(extension, "chunk-signature=")
+	if cr.signed && (!found || len(signature) != sha256.Size*2) {
+		return fmt.Errorf("missing chunk-signature: %w", ErrMalformedChunk)
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(cr.r, data); err != nil {
+		return fmt.Errorf("error reading chunk: %w", ErrMalformedChunk)
+	}
+	if size > 0 {
+		if line, err = cr.readLine(); err != nil || line != "" {
+			return fmt.Errorf("chunk is longer than its size: %w", ErrMalformedChunk)
+		}
+	}
+
+	if cr.signed {
+		stringToSign := strings.Join([]string{
+			chunkAlgorithm,
+			cr.auth.Date.UTC().Format(AmzDateFormat),
+			cr.auth.Scope(),
+			cr.prevSignature,
+			hex.EncodeToString(sha256Hash(nil)),
+			hex.EncodeToString(sha256Hash(data)),
+		}, "\n")
+		if err = cr.checkSignature(stringToSign, signature); err != nil {
+			return err
+		}
+	}
+
+	cr.read += size
+	if cr.decodedLength != -1 && cr.read > cr.decodedLength {
+		return ErrDecodedLengthMismatch
+	}
+	if cr.checksum != nil {
+		cr.checksum.Write(data)
+	}
+	if size > 0 {
+		cr.chunk = data
+		return nil
+	}
+
+	// The final chunk
+	if cr.decodedLength != -1 && cr.read != cr.decodedLength {
+		return ErrDecodedLengthMismatch
+	}
+	if cr.trailer != "" {
+		if err = cr.readTrailer(); err != nil {
+			return err
+		}
+	} else if line, err = cr.readLine(); err != nil || line != "" {
+		return fmt.Errorf("missing end of body: %w", ErrMalformedChunk)
+	}
+	return io.EOF
+}
+
+// readTrailer reads the trailing checksum and its signature, which ends with an empty line
+func (cr *chunkedReader) readTrailer() error {
+	var checksum, signature string
+	var canonicalTrailer bytes.Buffer
+	for {
+		line, err := cr.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("invalid trailer '%s': %w", line, ErrMalformedChunk)
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		switch name {
+		case trailerSignature:
+			signature = value
+		case cr.trailer:
+			checksum = value
+			canonicalTrailer.WriteString(name + ":" + value + "\n")
+		default:
+			return fmt.Errorf("undeclared trailer '%s': %w", name, ErrMalformedChunk)
+		}
+	}
+	if checksum == "" {
+		return fmt.Errorf("missing trailer %s: %w", cr.trailer, ErrMalformedChunk)
+	}
+
+	if cr.signed {
+		if signature == "" {
+			return fmt.Errorf("missing %s: %w", trailerSignature, ErrMalformedChunk)
+		}
+		stringToSign := strings.Join([]string{
+			trailerAlgorithm,
+			cr.auth.Date.UTC().Format(AmzDateFormat),
+			cr.auth.Scope(),
+			cr.prevSignature,
+			hex.EncodeToString(sha256Hash(canonicalTrailer.Bytes())),
+		}, "\n")
+		if err := cr.checkSignature(stringToSign, signature); err != nil {
+			return err
+		}
+	}
+
+	if checksum != base64.StdEncoding.EncodeToString(cr.checksum.Sum(nil)) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// checkSignature compares the signature of the string to sign, moving the chain on if it matches
+func (cr *chunkedReader) checkSignature(stringToSign, signature string) error {
+	expected := hex.EncodeToString(hmacSHA256(cr.signingKey, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrChunkSignatureDoesNotMatch
+	}
+	cr.prevSignature = signature
+	return nil
+}
+
+// readLine reads a CRLF terminated line without the CRLF
+func (cr *chunkedReader) readLine() (string, error) {
+	line, err := cr.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("line longer than %d bytes: %w", maxChunkLine, ErrMalformedChunk)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unexpected end of body: %w", ErrMalformedChunk)
+	}
+	line, found := bytes.CutSuffix(line, []byte("\r\n"))
+	if !found {
+		return "", fmt.Errorf("line not terminated by CRLF: %w", ErrMalformedChunk)
+	}
+	return string(line), nil
+}
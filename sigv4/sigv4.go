@@ -0,0 +1,337 @@
+// Package sigv4 verifies AWS Signature Version 4 requests, signed with either the Authorization header or
+// the query string of a presigned URL.
+package sigv4
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm       = "AWS4-HMAC-SHA256"
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	AmzDateFormat   = "20060102T150405Z"
+	scopeTerminator = "aws4_request"
+
+	// MaxSkew is how far the request time can be from now
+	MaxSkew = 15 * time.Minute
+	// MaxPresignExpires is the longest a presigned URL can be valid for
+	MaxPresignExpires = 7 * 24 * time.Hour
+	// maxHashedBodyBytes is the most that is read to hash a body without x-amz-content-sha256
+	maxHashedBodyBytes = 10_000_000
+)
+
+var (
+	ErrMissingAuth           = errors.New("request is not signed")
+	ErrMalformedAuth         = errors.New("malformed authorization")
+	ErrRequestTimeTooSkewed  = errors.New("request time too skewed")
+	ErrExpired               = errors.New("presigned url expired")
+	ErrSignatureDoesNotMatch = errors.New("signature does not match")
+)
+
+type (
+	// Auth is the parsed SigV4 auth of a request
+	Auth struct {
+		Credential    Credential
+		SignedHeaders []string
+		Signature     string
+		// The X-Amz-Date of the request
+		Date time.Time
+		// The hashed payload of the canonical request, or UNSIGNED-PAYLOAD
+		PayloadHash string
+		Presigned   bool
+	}
+
+	// Credential is the access key ID and credential scope, `keyID/date/region/service/aws4_request`
+	Credential struct {
+		KeyID   string
+		Date    string
+		Region  string
+		Service string
+		Request string
+	}
+)
+
+// Parse parses the auth of a request, rejecting malformed auth, requests outside the skew window, and
+// expired presigned URLs. The signature still needs to be checked with Verify.
+func Parse(r *http.Request, now time.Time) (*Auth, error) {
+	switch {
+	case r.Header.Get("Authorization") != "":
+		return parseHeader(r, now)
+	case r.URL.Query().Has("X-Amz-Signature"):
+		return parseQuery(r, now)
+	default:
+		return nil, ErrMissingAuth
+	}
+}
+
+func parseHeader(r *http.Request, now time.Time) (*Auth, error) {
+	algorithm, params, found := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !found || algorithm != Algorithm {
+		return nil, fmt.Errorf("algorithm must be %s: %w", Algorithm, ErrMalformedAuth)
+	}
+
+	auth := &Auth{}
+	for _, param := range strings.Split(params, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found {
+			return nil, fmt.Errorf("param '%s': %w", param, ErrMalformedAuth)
+		}
+		switch key {
+		case "Credential":
+			credential, err := parseCredential(value)
+			if err != nil {
+				return nil, err
+			}
+			auth.Credential = credential
+		case "SignedHeaders":
+			auth.SignedHeaders = strings.Split(value, ";")
+		case "Signature":
+			auth.Signature = value
+		}
+	}
+
+	var err error
+	if amzDate := r.Header.Get("X-Amz-Date"); amzDate != "" {
+		auth.Date, err = time.Parse(AmzDateFormat, amzDate)
+	} else {
+		// The Date header can be used instead
+		auth.Date, err = http.ParseTime(r.Header.Get("Date"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Amz-Date: %w", ErrMalformedAuth)
+	}
+	if err = auth.validate(); err != nil {
+		return nil, err
+	}
+	if auth.Date.Sub(now).Abs() > MaxSkew {
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	auth.PayloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if auth.PayloadHash == "" {
+		// Outside of S3 the header is optional, and the body is always signed
+		auth.PayloadHash, err = hashBody(r)
+		if err != nil {
+			return nil, fmt.Errorf("error in hashBody: %w", err)
+		}
+	}
+	return auth, nil
+}
+
+func parseQuery(r *http.Request, now time.Time) (*Auth, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != Algorithm {
+		return nil, fmt.Errorf("X-Amz-Algorithm must be %s: %w", Algorithm, ErrMalformedAuth)
+	}
+	credential, err := parseCredential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return nil, err
+	}
+	auth := &Auth{
+		Credential:    credential,
+		SignedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+		Signature:     query.Get("X-Amz-Signature"),
+		// Presigned URLs can't sign the payload, as the signature is made before the request
+		PayloadHash: UnsignedPayload,
+		Presigned:   true,
+	}
+	if query.Has("X-Amz-Content-Sha256") {
+		auth.PayloadHash = query.Get("X-Amz-Content-Sha256")
+	}
+	auth.Date, err = time.Parse(AmzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Amz-Date: %w", ErrMalformedAuth)
+	}
+	if err = auth.validate(); err != nil {
+		return nil, err
+	}
+
+	expiresSeconds, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	expires := time.Duration(expiresSeconds) * time.Second
+	if err != nil || expires <= 0 || expires > MaxPresignExpires {
+		return nil, fmt.Errorf("X-Amz-Expires must be 1 to %d seconds: %w", int(MaxPresignExpires.Seconds()), ErrMalformedAuth)
+	}
+	if auth.Date.Sub(now) > MaxSkew {
+		// Signed in the future
+		return nil, ErrRequestTimeTooSkewed
+	}
+	if now.After(auth.Date.Add(expires)) {
+		return nil, ErrExpired
+	}
+	return auth, nil
+}
+
+// parseCredential parses `keyID/date/region/service/aws4_request`
+func parseCredential(value string) (Credential, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 5 || slices.Contains(parts, "") || parts[4] != scopeTerminator {
+		return Credential{}, fmt.Errorf("credential must be keyID/date/region/service/%s: %w", scopeTerminator, ErrMalformedAuth)
+	}
+	return Credential{
+		KeyID:   parts[0],
+		Date:    parts[1],
+		Region:  parts[2],
+		Service: parts[3],
+		Request: parts[4],
+	}, nil
+}
+
+// validate checks the parts that are common to header and query auth
+func (a *Auth) validate() error {
+	if a.Credential.KeyID == "" {
+		return fmt.Errorf("missing credential: %w", ErrMalformedAuth)
+	}
+	if a.Credential.Date != a.Date.Format("20060102") {
+		return fmt.Errorf("credential date does not match X-Amz-Date: %w", ErrMalformedAuth)
+	}
+	if !slices.Contains(a.SignedHeaders, "host") {
+		return fmt.Errorf("host must be signed: %w", ErrMalformedAuth)
+	}
+	if len(a.Signature) != sha256.Size*2 {
+		return fmt.Errorf("signature must be %d hex characters: %w", sha256.Size*2, ErrMalformedAuth)
+	}
+	return nil
+}
+
+// hashBody hashes the request body, replacing it so it can still be read
+func hashBody(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return hex.EncodeToString(sha256Hash(nil)), nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHashedBodyBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("error in io.ReadAll: %w", err)
+	}
+	if len(body) > maxHashedBodyBytes {
+		return "", fmt.Errorf("body too large to hash without X-Amz-Content-Sha256: %w", ErrMalformedAuth)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return hex.EncodeToString(sha256Hash(body)), nil
+}
+
+// Verify checks the signature against each secret (so keys can be rotated), returning ErrSignatureDoesNotMatch
+// if none match. The signing key of the matching secret verifies the chunks of a streaming payload.
+func (a *Auth) Verify(r *http.Request, secrets []string) ([]byte, error) {
+	stringToSign := a.StringToSign(CanonicalRequest(r, a))
+	for _, secret := range secrets {
+		signingKey := a.SigningKey(secret)
+		signature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+		if hmac.Equal([]byte(signature), []byte(a.Signature)) {
+			return signingKey, nil
+		}
+	}
+	return nil, ErrSignatureDoesNotMatch
+}
+
+// Scope is the credential scope, `date/region/service/aws4_request`
+func (a *Auth) Scope() string {
+	return strings.Join([]string{a.Credential.Date, a.Credential.Region, a.Credential.Service, scopeTerminator}, "/")
+}
+
+func (a *Auth) StringToSign(canonicalRequest string) string {
+	return strings.Join([]string{
+		Algorithm,
+		a.Date.UTC().Format(AmzDateFormat),
+		a.Scope(),
+		hex.EncodeToString(sha256Hash([]byte(canonicalRequest))),
+	}, "\n")
+}
+
+// SigningKey derives the key for the credential scope from the secret
+func (a *Auth) SigningKey(secret string) []byte {
+	dateKey := hmacSHA256([]byte("AWS4"+secret), []byte(a.Credential.Date))
+	dateRegionKey := hmacSHA256(dateKey, []byte(a.Credential.Region))
+	dateRegionServiceKey := hmacSHA256(dateRegionKey, []byte(a.Credential.Service))
+	return hmacSHA256(dateRegionServiceKey, []byte(scopeTerminator))
+}
+
+// CanonicalRequest builds the canonical request of the signed parts of the request
+func CanonicalRequest(r *http.Request, a *Auth) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	signedHeaders := make([]string, 0, len(a.SignedHeaders))
+	for _, header := range a.SignedHeaders {
+		signedHeaders = append(signedHeaders, strings.ToLower(header))
+	}
+	slices.Sort(signedHeaders)
+	var canonicalHeaders strings.Builder
+	for _, header := range signedHeaders {
+		canonicalHeaders.WriteString(header + ":" + canonicalHeaderValue(r, header) + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		path,
+		CanonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		a.PayloadHash,
+	}, "\n")
+}
+
+// canonicalHeaderValue joins multiple values with commas, trimming and collapsing whitespace in each
+func canonicalHeaderValue(r *http.Request, header string) string {
+	var values []string
+	switch header {
+	case "host":
+		// The server moves the host header to the request
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values(header)
+		if len(values) == 0 && r.ContentLength > 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(header)
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// CanonicalQuery sorts and URI encodes the query, leaving out the signature of presigned URLs
+func CanonicalQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		if key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, URIEncode(key)+"="+URIEncode(value))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// URIEncode encodes everything except the unreserved characters (A-Z, a-z, 0-9, -, _, ., ~)
+func URIEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	hash := hmac.New(sha256.New, key)
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func sha256Hash(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
@@ -0,0 +1,264 @@
+package sigv4
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	testKeyID  = "AKIDEXAMPLE"
+	testSecret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testScope  = "AKIDEXAMPLE/20150830/us-east-1/service/aws4_request"
+)
+
+var testTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+// readVectorRequest parses a request from the AWS SigV4 test suite format
+func readVectorRequest(t *testing.T, raw string) *http.Request {
+	t.Helper()
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestVectors are from the AWS SigV4 test suite
+func TestVectors(t *testing.T) {
+	vectors := []struct {
+		name          string
+		request       string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			request:       "GET / HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			request:       "GET /?Param2=value2&Param1=value1 HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "get-vanilla-query-unreserved",
+			request:       "GET /?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+		{
+			name:          "get-vanilla-utf8-query",
+			request:       "GET /?ሴ=bar HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "2cdec8eed098649ff3a119c94853b13c643bcf08f8b0a1d91e12c9027818dd04",
+		},
+		{
+			name:          "get-utf8",
+			request:       "GET /ሴ HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		},
+		{
+			name:          "get-header-key-duplicate",
+			request:       "GET / HTTP/1.1\r\nHost:example.amazonaws.com\r\nMy-Header1:value2\r\nMy-Header1:value2\r\nMy-Header1:value1\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "c9d5ea9f3f72853aea855b47ea873832890dbdd183b4468f858259531a5138ea",
+		},
+		{
+			name:          "get-header-value-trim",
+			request:       "GET / HTTP/1.1\r\nHost:example.amazonaws.com\r\nMy-Header1: value1\r\nMy-Header2: \"a   b   c\"\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;my-header1;my-header2;x-amz-date",
+			signature:     "acc3ed3afb60bb290fc8d2dd0098b9911fcaa05412b367055dee359757a9c736",
+		},
+		{
+			name:          "post-vanilla",
+			request:       "POST / HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-vanilla-query",
+			request:       "POST /?Param1=value1 HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;x-amz-date",
+			signature:     "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11",
+		},
+		{
+			name:          "post-header-key-sort",
+			request:       "POST / HTTP/1.1\r\nHost:example.amazonaws.com\r\nMy-Header1:value1\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "c5410059b04c1ee005303aed430f6e6645f61f4dc9e1461ec8f8916fdf18852c",
+		},
+		{
+			name:          "post-header-value-case",
+			request:       "POST / HTTP/1.1\r\nHost:example.amazonaws.com\r\nMy-Header1:VALUE1\r\nX-Amz-Date:20150830T123600Z\r\n\r\n",
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "cdbc9802e29d2942e5e10b5bccfdd67c5f22c7c4e8ae67b53629efa58b974b7d",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			request:       "POST / HTTP/1.1\r\nContent-Type:application/x-www-form-urlencoded\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\nContent-Length:13\r\n\r\nParam1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:          "post-x-www-form-urlencoded-parameters",
+			request:       "POST / HTTP/1.1\r\nContent-Type:application/x-www-form-urlencoded; charset=utf8\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\nContent-Length:13\r\n\r\nParam1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "1a72ec8f64bd914b0e42e42607c7fbce7fb2c7465f63e3092b3b0d39fa77a6fe",
+		},
+	}
+
+	for _, vector := range vectors {
+		t.Run(vector.name, func(t *testing.T) {
+			r := readVectorRequest(t, vector.request)
+			r.Header.Set("Authorization", Algorithm+" Credential="+testScope+", SignedHeaders="+vector.signedHeaders+", Signature="+vector.signature)
+
+			auth, err := Parse(r, testTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if auth.Credential.Region != "us-east-1" || auth.Credential.Service != "service" {
+				t.Fatalf("scope not parsed: %+v", auth.Credential)
+			}
+			if _, err = auth.Verify(r, []string{"wrong", testSecret}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = auth.Verify(r, []string{"wrong"}); !errors.Is(err, ErrSignatureDoesNotMatch) {
+				t.Fatalf("wrong secret verified: %v", err)
+			}
+		})
+	}
+}
+
+// TestSDKSigner checks requests signed by the AWS SDK, which S3 clients sign without double escaping the path
+func TestSDKSigner(t *testing.T) {
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+	creds := aws.Credentials{AccessKeyID: testKeyID, SecretAccessKey: testSecret}
+
+	r, err := http.NewRequest(http.MethodGet, "http://localhost:8080/bucket/a%20b/c~d.parquet?list-type=2&prefix=a+b&delimiter=%2F", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Add("X-Amz-Meta-Values", "one")
+	r.Header.Add("X-Amz-Meta-Values", "  two   three ")
+	r.Header.Set("X-Amz-Content-Sha256", UnsignedPayload)
+	now := time.Now()
+	err = signer.SignHTTP(context.Background(), creds, r, UnsignedPayload, "s3", "eu-west-2", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := Parse(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Credential.Region != "eu-west-2" || auth.Credential.Service != "s3" {
+		t.Fatalf("scope not parsed: %+v", auth.Credential)
+	}
+	if _, err = auth.Verify(r, []string{testSecret}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("X-Amz-Meta-Values", "tampered")
+	if _, err = auth.Verify(r, []string{testSecret}); !errors.Is(err, ErrSignatureDoesNotMatch) {
+		t.Fatalf("tampered header verified: %v", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	get := "GET / HTTP/1.1\r\nHost:example.amazonaws.com\r\nX-Amz-Date:20150830T123600Z\r\n\r\n"
+	signature := "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	tests := []struct {
+		name   string
+		auth   string
+		now    time.Time
+		expect error
+	}{
+		{
+			name:   "unsupported algorithm",
+			auth:   "AWS4-HMAC-SHA512 Credential=" + testScope + ", SignedHeaders=host;x-amz-date, Signature=" + signature,
+			now:    testTime,
+			expect: ErrMalformedAuth,
+		},
+		{
+			name:   "short credential",
+			auth:   Algorithm + " Credential=AKIDEXAMPLE/20150830/us-east-1, SignedHeaders=host;x-amz-date, Signature=" + signature,
+			now:    testTime,
+			expect: ErrMalformedAuth,
+		},
+		{
+			name:   "scope date mismatch",
+			auth:   Algorithm + " Credential=AKIDEXAMPLE/20150831/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + signature,
+			now:    testTime,
+			expect: ErrMalformedAuth,
+		},
+		{
+			name:   "host not signed",
+			auth:   Algorithm + " Credential=" + testScope + ", SignedHeaders=x-amz-date, Signature=" + signature,
+			now:    testTime,
+			expect: ErrMalformedAuth,
+		},
+		{
+			name:   "short signature",
+			auth:   Algorithm + " Credential=" + testScope + ", SignedHeaders=host;x-amz-date, Signature=5fa0",
+			now:    testTime,
+			expect: ErrMalformedAuth,
+		},
+		{
+			name:   "garbage",
+			auth:   Algorithm + " ,,,===",
+			now:    testTime,
+			expect: ErrMalformedAuth,
+		},
+		{
+			name:   "too old",
+			auth:   Algorithm + " Credential=" + testScope + ", SignedHeaders=host;x-amz-date, Signature=" + signature,
+			now:    testTime.Add(MaxSkew + time.Second),
+			expect: ErrRequestTimeTooSkewed,
+		},
+		{
+			name:   "in the future",
+			auth:   Algorithm + " Credential=" + testScope + ", SignedHeaders=host;x-amz-date, Signature=" + signature,
+			now:    testTime.Add(-MaxSkew - time.Second),
+			expect: ErrRequestTimeTooSkewed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := readVectorRequest(t, get)
+			r.Header.Set("Authorization", test.auth)
+			_, err := Parse(r, test.now)
+			if !errors.Is(err, test.expect) {
+				t.Fatalf("expected %v, got %v", test.expect, err)
+			}
+		})
+	}
+
+	t.Run("within skew", func(t *testing.T) {
+		r := readVectorRequest(t, get)
+		r.Header.Set("Authorization", Algorithm+" Credential="+testScope+", SignedHeaders=host;x-amz-date, Signature="+signature)
+		if _, err := Parse(r, testTime.Add(MaxSkew)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		r := readVectorRequest(t, get)
+		if _, err := Parse(r, testTime); !errors.Is(err, ErrMissingAuth) {
+			t.Fatalf("expected ErrMissingAuth, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/sonic"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+var (
+	ErrUnknownCredentialsRef      = errors.New("unknown upstream credentials ref")
+	ErrInvalidUpstreamCredentials = errors.New("invalid upstream credentials format")
+	upstreamCredentials           map[string]Credentials
+	upstreamCredentialsErr        error
+	upstreamCredentialsOnce       sync.Once
+)
+
+// Credentials sign requests to a real bucket
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// ResolveCredentialsRef returns the per-tenant credentials named by ref, from UPSTREAM_CREDENTIALS and
+// UPSTREAM_CREDENTIALS_FILE on this node. Only the ref is passed around, so secrets never leave the node.
+func ResolveCredentialsRef(ref string) (Credentials, error) {
+	upstreamCredentialsOnce.Do(func() {
+		upstreamCredentials, upstreamCredentialsErr = loadUpstreamCredentials(utils.UpstreamCredentials, utils.UpstreamCredentialsFile)
+	})
+	if upstreamCredentialsErr != nil {
+		return Credentials{}, fmt.Errorf("error in loadUpstreamCredentials: %w", upstreamCredentialsErr)
+	}
+	creds, exists := upstreamCredentials[ref]
+	if !exists {
+		return Credentials{}, fmt.Errorf("ref '%s': %w", ref, ErrUnknownCredentialsRef)
+	}
+	return creds, nil
+}
+
+// loadUpstreamCredentials parses `ref:keyID:secret,ref2:keyID2:secret2`, and a JSON file in the format
+// `{"ref": {"AccessKeyID": "...", "SecretAccessKey": "..."}}`
+func loadUpstreamCredentials(creds, fileName string) (map[string]Credentials, error) {
+	refs := map[string]Credentials{}
+	if fileName != "" {
+		fileBytes, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("error in os.ReadFile: %w", err)
+		}
+		err = sonic.Unmarshal(fileBytes, &refs)
+		if err != nil {
+			return nil, fmt.Errorf("error in sonic.Unmarshal: %w", err)
+		}
+	}
+	for _, cred := range strings.Split(creds, ",") {
+		if cred == "" {
+			continue
+		}
+		parts := strings.SplitN(cred, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("credentials for ref '%s': %w", parts[0], ErrInvalidUpstreamCredentials)
+		}
+		refs[parts[0]] = Credentials{
+			AccessKeyID:     parts[1],
+			SecretAccessKey: parts[2],
+		}
+	}
+	return refs, nil
+}
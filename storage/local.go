@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type (
+	// LocalObjectStore serves objects from a directory, where keys are slash separated paths under the directory.
+	// Symlinks are followed as long as they resolve under the directory, but symlinked directories aren't listed.
+	LocalObjectStore struct {
+		root string
+	}
+
+	// limitedFile reads a range of a file, closing the file when done
+	limitedFile struct {
+		io.Reader
+		file *os.File
+	}
+)
+
+// NewLocalObjectStore uses the bucket as a subdirectory of root
+func NewLocalObjectStore(root, bucket string) *LocalObjectStore {
+	return &LocalObjectStore{
+		root: filepath.Join(root, bucket),
+	}
+}
+
+// filePath is the path of the key on disk. Keys can't escape the root.
+func (s *LocalObjectStore) filePath(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// resolvedPath is the path of the key on disk with symlinks followed, which is ErrObjectNotFound if a symlink
+// escapes the root
+func (s *LocalObjectStore) resolvedPath(key string) (string, error) {
+	filePath, err := filepath.EvalSymlinks(s.filePath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrObjectNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error in filepath.EvalSymlinks: %w", err)
+	}
+	// The root may itself be under a symlink (e.g. /var -> /private/var)
+	root, err := filepath.EvalSymlinks(s.root)
+	if err != nil {
+		return "", fmt.Errorf("error in filepath.EvalSymlinks for root: %w", err)
+	}
+	relPath, err := filepath.Rel(root, filePath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", ErrObjectNotFound
+	}
+	return filePath, nil
+}
+
+func (s *LocalObjectStore) List(ctx context.Context, prefix, startAfter string) ([]ObjectInfo, error) {
+	// Only walk the deepest directory the prefix is in
+	walkDir := s.root
+	if dirInd := strings.LastIndex(prefix, "/"); dirInd != -1 {
+		walkDir = s.filePath(prefix[:dirInd])
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(walkDir, func(filePath string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return fmt.Errorf("error in filepath.Rel: %w", err)
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			// Only listed if it can be read, with the info of what it points to
+			info, err := s.Head(ctx, key)
+			if errors.Is(err, ErrObjectNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			objects = append(objects, *info)
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error in Info: %w", err)
+		}
+		objects = append(objects, fileInfo(key, stat))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in filepath.WalkDir: %w", err)
+	}
+
+	slices.SortFunc(objects, func(a, b ObjectInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return objects, nil
+}
+
+func (s *LocalObjectStore) Get(ctx context.Context, key string) (*Object, error) {
+	file, info, err := s.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		ObjectInfo:    *info,
+		Body:          file,
+		ContentLength: info.Size,
+	}, nil
+}
+
+func (s *LocalObjectStore) GetRange(ctx context.Context, key string, byteRange ByteRange) (*Object, error) {
+	file, info, err := s.open(key)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := byteRange.Resolve(info.Size)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	_, err = file.Seek(start, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error in Seek: %w", err)
+	}
+	return &Object{
+		ObjectInfo: *info,
+		Body: &limitedFile{
+			Reader: io.LimitReader(file, end-start+1),
+			file:   file,
+		},
+		ContentLength: end - start + 1,
+		ContentRange:  contentRange(start, end, info.Size),
+	}, nil
+}
+
+func (s *LocalObjectStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	filePath, err := s.resolvedPath(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && stat.IsDir()) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in os.Stat: %w", err)
+	}
+	info := fileInfo(key, stat)
+	return &info, nil
+}
+
+func (s *LocalObjectStore) open(key string) (*os.File, *ObjectInfo, error) {
+	filePath, err := s.resolvedPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in os.Open: %w", err)
+	}
+	stat, err := file.Stat()
+	if err == nil && stat.IsDir() {
+		err = ErrObjectNotFound
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	info := fileInfo(key, stat)
+	return file, &info, nil
+}
+
+func fileInfo(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime().UTC(),
+		ETag:         fmt.Sprintf(`"%x-%x"`, stat.Size(), stat.ModTime().UnixNano()),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+	}
+}
+
+func (f *limitedFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalObjectStore(t *testing.T) {
+	root := t.TempDir()
+	for _, key := range []string{"t/_log/1_a.jsonl", "t/_log/2_b.jsonl", "t/_data/a.parquet", "other/x"} {
+		fileName := filepath.Join(root, "bucket", filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte("0123456789"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	store, err := NewObjectStore(ctx, Target{Bucket: "bucket", Endpoint: "file://" + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := store.List(ctx, "t/_log", "t/_log/1_a.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "t/_log/2_b.jsonl" || objects[0].Size != 10 {
+		t.Fatalf("bad list %+v", objects)
+	}
+	objects, err = store.List(ctx, "missing/", "")
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("bad list of missing prefix %+v %v", objects, err)
+	}
+
+	obj, err := store.GetRange(ctx, "t/_data/a.parquet", *ParseRange("bytes=-3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if string(body) != "789" || obj.ContentRange != "bytes 7-9/10" || obj.ContentLength != 3 {
+		t.Fatalf("bad range %s %+v", body, obj)
+	}
+
+	_, err = store.GetRange(ctx, "t/_data/a.parquet", ByteRange{Start: 10, End: -1})
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("expected invalid range, got %v", err)
+	}
+	_, err = store.Head(ctx, "../../etc/passwd")
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected not found escaping the root, got %v", err)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := map[string]*ByteRange{
+		"bytes=0-9":     {Start: 0, End: 9},
+		"bytes=5-":      {Start: 5, End: -1},
+		"bytes=-5":      {Start: -5, End: -1},
+		"bytes=0-1,3-4": nil,
+		"bytes=9-1":     nil,
+		"items=0-1":     nil,
+	}
+	for header, expected := range tests {
+		byteRange := ParseRange(header)
+		if (byteRange == nil) != (expected == nil) || (byteRange != nil && *byteRange != *expected) {
+			t.Fatalf("bad range for %s: %+v", header, byteRange)
+		}
+	}
+}
+
+func TestLocalObjectStoreSymlinks(t *testing.T) {
+	root := t.TempDir()
+	for _, fileName := range []string{"bucket/t/_data/a.parquet", "outside/secret", "outside/dir/secret"} {
+		fileName = filepath.Join(root, filepath.FromSlash(fileName))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte("0123456789"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"bucket/t/_data/b.parquet": "a.parquet",
+		"bucket/t/_data/secret":    filepath.Join(root, "outside", "secret"),
+		"bucket/t/_data/relative":  "../../../outside/secret",
+		"bucket/t/dir":             filepath.Join(root, "outside", "dir"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	store, err := NewObjectStore(ctx, Target{Bucket: "bucket", Endpoint: "file://" + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A symlink within the root is served, and listed like what it points to
+	obj, err := store.Get(ctx, "t/_data/b.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Body.Close()
+	objects, err := store.List(ctx, "t/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[1].Key != "t/_data/b.parquet" || objects[1].Size != 10 {
+		t.Fatalf("bad list %+v", objects)
+	}
+
+	for _, key := range []string{"t/_data/secret", "t/_data/relative", "t/dir/secret"} {
+		if _, err = store.Get(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Fatalf("%s: expected not found escaping the root, got %v", key, err)
+		}
+		if _, err = store.Head(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Fatalf("%s: expected not found escaping the root, got %v", key, err)
+		}
+	}
+}
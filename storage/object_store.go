@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrInvalidRange   = errors.New("range not satisfiable")
+)
+
+// localEndpointScheme selects the local filesystem store, e.g. `file:///var/lib/icedb` with the bucket as a subdirectory
+const localEndpointScheme = "file://"
+
+type (
+	// ObjectStore is where the IceDB log and data files live
+	ObjectStore interface {
+		// List returns the objects with the prefix after startAfter, sorted by key
+		List(ctx context.Context, prefix, startAfter string) ([]ObjectInfo, error)
+		// Get returns ErrObjectNotFound if the object does not exist
+		Get(ctx context.Context, key string) (*Object, error)
+		// GetRange returns ErrInvalidRange if the range starts after the end of the object
+		GetRange(ctx context.Context, key string, byteRange ByteRange) (*Object, error)
+		Head(ctx context.Context, key string) (*ObjectInfo, error)
+	}
+
+	ObjectInfo struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+		ETag         string
+		ContentType  string
+	}
+
+	// Object must have its Body closed
+	Object struct {
+		ObjectInfo
+		Body io.ReadCloser
+		// The length of Body, which is less than Size for a range
+		ContentLength int64
+		// The Content-Range header for a range, e.g. `bytes 0-99/1000`
+		ContentRange string
+	}
+
+	// ByteRange is an HTTP byte range of an object
+	ByteRange struct {
+		// The first byte, or if negative the number of bytes from the end of the object
+		Start int64
+		// The last byte (inclusive), or -1 to read to the end of the object
+		End int64
+	}
+)
+
+// NewObjectStore creates the store for the target, which is the local filesystem if the endpoint is a `file://` URL
+func NewObjectStore(ctx context.Context, t Target) (ObjectStore, error) {
+	if root, isLocal := strings.CutPrefix(t.Endpoint, localEndpointScheme); isLocal {
+		return NewLocalObjectStore(root, t.Bucket), nil
+	}
+	store, err := NewS3ObjectStore(ctx, t)
+	if err != nil {
+		return nil, fmt.Errorf("error in NewS3ObjectStore: %w", err)
+	}
+	return store, nil
+}
+
+// ParseRange parses a Range header with a single range. Multiple ranges and invalid ranges return nil, so
+// the whole object is served.
+func ParseRange(header string) *ByteRange {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return nil
+	}
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return nil
+	}
+
+	if startStr == "" {
+		// Suffix range, the last N bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return nil
+		}
+		return &ByteRange{Start: -suffix, End: -1}
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return nil
+	}
+	byteRange := &ByteRange{Start: start, End: -1}
+	if endStr != "" {
+		byteRange.End, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || byteRange.End < start {
+			return nil
+		}
+	}
+	return byteRange
+}
+
+// String formats the range as a Range header
+func (r ByteRange) String() string {
+	switch {
+	case r.Start < 0:
+		return fmt.Sprintf("bytes=%d", r.Start)
+	case r.End < 0:
+		return fmt.Sprintf("bytes=%d-", r.Start)
+	default:
+		return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+	}
+}
+
+// Resolve returns the first and last byte (inclusive) of the range within an object of size
+func (r ByteRange) Resolve(size int64) (int64, int64, error) {
+	start, end := r.Start, r.End
+	if start < 0 {
+		start = max(size+start, 0)
+		end = size - 1
+	}
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	if start >= size {
+		return 0, 0, ErrInvalidRange
+	}
+	return start, end, nil
+}
+
+// contentRange formats the Content-Range header for the resolved range
+func contentRange(start, end, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+	"github.com/samber/lo"
+)
+
+type (
+	// Target is the real bucket (and where it lives) that a virtual bucket resolves to
+	Target struct {
+		Bucket       string
+		Endpoint     string
+		Region       string
+		UsePathStyle bool
+		// The credentials requests to the real bucket are signed with
+		AccessKeyID     string
+		SecretAccessKey string
+	}
+
+	// S3ObjectStore is a bucket on S3 or an S3 compatible cluster
+	S3ObjectStore struct {
+		client *s3.Client
+		bucket string
+	}
+)
+
+// s3Clients is an LRU of clients by clientKey, bounded by S3_CLIENT_CACHE_SIZE as there is one per tenant credentials
+var (
+	s3Clients    = map[string]*list.Element{}
+	s3ClientsLRU = list.New() // of *s3ClientEntry, front is most recent
+	s3ClientsMu  sync.Mutex
+)
+
+type s3ClientEntry struct {
+	key    string
+	client *s3.Client
+}
+
+// DefaultTarget is the bucket configured through the environment
+func DefaultTarget() Target {
+	return Target{
+		Bucket:          utils.S3Bucket,
+		Endpoint:        utils.S3Url,
+		Region:          utils.AWSRegion,
+		UsePathStyle:    utils.S3UsePath,
+		AccessKeyID:     utils.AWSKeyID,
+		SecretAccessKey: utils.AWSSecretKey,
+	}
+}
+
+// clientKey includes a hash of the secret, so a rotated secret gets a new client
+func (t Target) clientKey() string {
+	secretHash := sha256.Sum256([]byte(t.SecretAccessKey))
+	return fmt.Sprintf("%s|%s|%t|%s|%x", t.Endpoint, t.Region, t.UsePathStyle, t.AccessKeyID, secretHash[:8])
+}
+
+// GetS3Client returns a cached S3 client for the endpoint and credentials of the target, creating one if needed
+func GetS3Client(ctx context.Context, t Target) (*s3.Client, error) {
+	s3ClientsMu.Lock()
+	defer s3ClientsMu.Unlock()
+
+	key := t.clientKey()
+	if elem, exists := s3Clients[key]; exists {
+		s3ClientsLRU.MoveToFront(elem)
+		return elem.Value.(*s3ClientEntry).client, nil
+	}
+
+	// The SDK signs every request for the real bucket's host and path
+	s3Creds := credentials.NewStaticCredentialsProvider(t.AccessKeyID, t.SecretAccessKey, "")
+	s3Cfg, err := config.LoadDefaultConfig(ctx, config.WithCredentialsProvider(s3Creds), config.WithRegion(t.Region))
+	if err != nil {
+		return nil, fmt.Errorf("error in config.LoadDefaultConfig: %w", err)
+	}
+	client := s3.NewFromConfig(s3Cfg, func(options *s3.Options) {
+		options.BaseEndpoint = utils.Ptr(t.Endpoint)
+		options.UsePathStyle = t.UsePathStyle
+	})
+	s3Clients[key] = s3ClientsLRU.PushFront(&s3ClientEntry{key: key, client: client})
+	for int64(s3ClientsLRU.Len()) > max(utils.S3ClientCacheSize, 1) {
+		evicted := s3ClientsLRU.Remove(s3ClientsLRU.Back()).(*s3ClientEntry)
+		delete(s3Clients, evicted.key)
+	}
+	return client, nil
+}
+
+// ID identifies where the target's objects live, for caching
+func (t Target) ID() string {
+	return t.Endpoint + "|" + t.Bucket
+}
+
+func NewS3ObjectStore(ctx context.Context, t Target) (*S3ObjectStore, error) {
+	client, err := GetS3Client(ctx, t)
+	if err != nil {
+		return nil, fmt.Errorf("error in GetS3Client: %w", err)
+	}
+	return &S3ObjectStore{
+		client: client,
+		bucket: t.Bucket,
+	}, nil
+}
+
+func (s *S3ObjectStore) List(ctx context.Context, prefix, startAfter string) ([]ObjectInfo, error) {
+	var contToken *string
+	var objects []ObjectInfo
+	for {
+		listObjects, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &s.bucket,
+			ContinuationToken: contToken,
+			MaxKeys:           1000,
+			Prefix:            &prefix,
+			StartAfter:        lo.Ternary(startAfter == "", nil, &startAfter),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error in ListObjectsV2: %w", err)
+		}
+		for _, object := range listObjects.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          utils.Deref(object.Key, ""),
+				Size:         object.Size,
+				LastModified: utils.Deref(object.LastModified, time.Time{}),
+				ETag:         utils.Deref(object.ETag, ""),
+			})
+		}
+		if !listObjects.IsTruncated {
+			break
+		}
+		contToken = listObjects.NextContinuationToken
+	}
+	return objects, nil
+}
+
+func (s *S3ObjectStore) Get(ctx context.Context, key string) (*Object, error) {
+	return s.get(ctx, key, nil)
+}
+
+func (s *S3ObjectStore) GetRange(ctx context.Context, key string, byteRange ByteRange) (*Object, error) {
+	return s.get(ctx, key, &byteRange)
+}
+
+func (s *S3ObjectStore) get(ctx context.Context, key string, byteRange *ByteRange) (*Object, error) {
+	input := &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}
+	if byteRange != nil {
+		input.Range = utils.Ptr(byteRange.String())
+	}
+	output, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error in GetObject: %w", mapS3Error(err))
+	}
+	obj := &Object{
+		ObjectInfo: ObjectInfo{
+			Key:          key,
+			Size:         output.ContentLength,
+			LastModified: utils.Deref(output.LastModified, time.Time{}),
+			ETag:         utils.Deref(output.ETag, ""),
+			ContentType:  utils.Deref(output.ContentType, ""),
+		},
+		Body:          output.Body,
+		ContentLength: output.ContentLength,
+		ContentRange:  utils.Deref(output.ContentRange, ""),
+	}
+	if obj.ContentRange != "" {
+		// The total size is after the slash
+		_, total, _ := strings.Cut(obj.ContentRange, "/")
+		obj.Size, _ = strconv.ParseInt(total, 10, 64)
+	}
+	return obj, nil
+}
+
+func (s *S3ObjectStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in HeadObject: %w", mapS3Error(err))
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         output.ContentLength,
+		LastModified: utils.Deref(output.LastModified, time.Time{}),
+		ETag:         utils.Deref(output.ETag, ""),
+		ContentType:  utils.Deref(output.ContentType, ""),
+	}, nil
+}
+
+// mapS3Error wraps the store errors for S3 API errors, so callers don't need to know about S3
+func mapS3Error(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%w: %w", ErrObjectNotFound, err)
+	case "InvalidRange":
+		return fmt.Errorf("%w: %w", ErrInvalidRange, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/GoAPITemplate/sigv4"
+	"github.com/danthegoodman1/GoAPITemplate/utils"
+)
+
+// TestS3ObjectStoreSigning checks requests to the real bucket are signed with the target's credentials, for its
+// host and path
+func TestS3ObjectStoreSigning(t *testing.T) {
+	var requests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		auth, err := sigv4.Parse(r, time.Now())
+		if err != nil {
+			t.Errorf("upstream request not signed: %v", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if auth.Credential.KeyID != "tenant" || auth.Credential.Region != "eu-west-1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if _, err = auth.Verify(r, []string{"tenant-secret"}); err != nil {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>")
+			return
+		}
+		if r.URL.Path != "/real-bucket/tenant/_data/a b.parquet" {
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Length", "10")
+		w.Header().Set("ETag", `"abc"`)
+		io.WriteString(w, "0123456789")
+	}))
+	defer upstream.Close()
+
+	ctx := context.Background()
+	target := Target{
+		Bucket:          "real-bucket",
+		Endpoint:        upstream.URL,
+		Region:          "eu-west-1",
+		UsePathStyle:    true,
+		AccessKeyID:     "tenant",
+		SecretAccessKey: "tenant-secret",
+	}
+	store, err := NewS3ObjectStore(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := store.Get(ctx, "tenant/_data/a b.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil || string(body) != "0123456789" {
+		t.Fatalf("got %q, %v", body, err)
+	}
+
+	// A different secret for the same key ID gets its own client
+	target.SecretAccessKey = "wrong"
+	store, err = NewS3ObjectStore(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(ctx, "tenant/_data/a b.parquet"); err == nil {
+		t.Fatal("request signed with the wrong secret succeeded")
+	}
+	if requests.Load() < 2 {
+		t.Fatalf("expected both requests upstream, got %d", requests.Load())
+	}
+}
+
+func TestS3ClientCacheBounded(t *testing.T) {
+	defer func(size int64) { utils.S3ClientCacheSize = size }(utils.S3ClientCacheSize)
+	utils.S3ClientCacheSize = 2
+
+	ctx := context.Background()
+	targets := []Target{
+		{Endpoint: "http://localhost:9000", AccessKeyID: "a", SecretAccessKey: "a"},
+		{Endpoint: "http://localhost:9000", AccessKeyID: "b", SecretAccessKey: "b"},
+		{Endpoint: "http://localhost:9000", AccessKeyID: "b", SecretAccessKey: "rotated"},
+	}
+	for _, target := range targets {
+		if _, err := GetS3Client(ctx, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s3Clients) != 2 || s3ClientsLRU.Len() != 2 {
+		t.Fatalf("expected 2 cached clients, got %d", len(s3Clients))
+	}
+	if _, exists := s3Clients[targets[0].clientKey()]; exists {
+		t.Fatal("least recently used client was not evicted")
+	}
+}
+
+func TestLoadUpstreamCredentials(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "creds.json")
+	err := os.WriteFile(fileName, []byte(`{"file-ref": {"AccessKeyID": "fileKey", "SecretAccessKey": "fileSecret"}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, err := loadUpstreamCredentials("env-ref:envKey:env:secret", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs["env-ref"] != (Credentials{AccessKeyID: "envKey", SecretAccessKey: "env:secret"}) || refs["file-ref"].SecretAccessKey != "fileSecret" {
+		t.Fatalf("bad refs %+v", refs)
+	}
+	if _, err = loadUpstreamCredentials("missing-secret:key", ""); !errors.Is(err, ErrInvalidUpstreamCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"github.com/samber/lo"
 	"os"
 	"strings"
 )
@@ -13,27 +12,75 @@ var (
 	MyHost     = MustEnv("MY_HOST")
 	MyURLParts = strings.Split(MyHost, ".")
 
-	AWSKeyID           = MustEnv("AWS_KEY_ID")
-	AWSSecretKey       = MustEnv("AWS_KEY_SECRET")
-	S3Bucket           = MustEnv("S3_BUCKET")
-	S3BucketPtr        = Ptr(S3Bucket)
-	S3UsePath          = os.Getenv("S3_USE_PATH") == "1"
-	S3Url              = MustEnv("S3_URL")
-	S3UrlPtr           = Ptr(S3Url)
-	S3UrlWithSubdomain = lo.Ternary(S3UsePath, S3Url, AddBucketSubdomain(S3Url, S3Bucket))
-	AWSRegion          = MustEnv("AWS_REGION")
-	AWSRegionPtr       = Ptr(AWSRegion)
+	AWSKeyID     = MustEnv("AWS_KEY_ID")
+	AWSSecretKey = MustEnv("AWS_KEY_SECRET")
+	// Defaults for when the lookup does not return the bucket, endpoint, region, or path style
+	S3Bucket  = MustEnv("S3_BUCKET")
+	S3UsePath = os.Getenv("S3_USE_PATH") == "1"
+	S3Url     = MustEnv("S3_URL")
+	AWSRegion = MustEnv("AWS_REGION")
+	// Per-tenant credentials for real buckets, named by the lookup's CredentialsRef: ref:keyID:secret,ref2:keyID2:secret2
+	UpstreamCredentials = os.Getenv("UPSTREAM_CREDENTIALS")
+	// JSON file of {"ref": {"AccessKeyID": "...", "SecretAccessKey": "..."}}, merged with UPSTREAM_CREDENTIALS
+	UpstreamCredentialsFile = os.Getenv("UPSTREAM_CREDENTIALS_FILE")
+	// Max cached S3 clients, one per endpoint and credentials
+	S3ClientCacheSize = GetEnvOrDefaultInt("S3_CLIENT_CACHE_SIZE", 100)
 
-	LookupURL  = MustEnv("LOOKUP_URL")
-	LookupAuth = os.Getenv("LOOKUP_AUTH")
+	// http, file, or sql
+	LookupBackend = GetEnvOrDefault("LOOKUP_BACKEND", "http")
+	// Required when LOOKUP_BACKEND=http or CREDENTIAL_STORE=lookup
+	LookupURL     = os.Getenv("LOOKUP_URL")
+	LookupAuth    = os.Getenv("LOOKUP_AUTH")
+	LookupRetries = GetEnvOrDefaultInt("LOOKUP_RETRIES", 3)
+	// Consecutive failed lookup requests before the circuit breaker opens
+	LookupBreakerFailures        = GetEnvOrDefaultInt("LOOKUP_BREAKER_FAILURES", 5)
+	LookupBreakerCooldownSeconds = GetEnvOrDefaultInt("LOOKUP_BREAKER_COOLDOWN_SECONDS", 10)
+	// YAML or JSON file when LOOKUP_BACKEND=file
+	LookupFile            = os.Getenv("LOOKUP_FILE")
+	LookupFilePollSeconds = GetEnvOrDefaultInt("LOOKUP_FILE_POLL_SECONDS", 5)
+	// Postgres or CockroachDB DSN when LOOKUP_BACKEND=sql
+	LookupDSN = os.Getenv("LOOKUP_DSN")
+
+	// env, file, or lookup
+	CredentialStore = GetEnvOrDefault("CREDENTIAL_STORE", "env")
+	// keyID:secret1|secret2,keyID2:secret3 when CREDENTIAL_STORE=env
+	Credentials = os.Getenv("CREDENTIALS")
+	// JSON file of {"keyID": ["secret1", "secret2"]} when CREDENTIAL_STORE=file
+	CredentialsFile = os.Getenv("CREDENTIALS_FILE")
 
 	CacheEnabled = os.Getenv("CACHE_ENABLED") == "1"
 	// http://x:y,http://z:y,... MUST INCLUDE SELF! Only need to include self to cache as a single node
 	CachePeers = strings.Split(os.Getenv("CACHE_PEERS"), ",")
 	// http://x.x.x.x:yyyy
-	CacheSelfAddr   = os.Getenv("CACHE_SELF_ADDR")
-	CacheBytes      = GetEnvOrDefaultInt("CACHE_BYTES", 100_000_000) // 100MB
-	CacheTTLSeconds = GetEnvOrDefaultInt("CACHE_SECONDS", 10)
+	CacheSelfAddr = os.Getenv("CACHE_SELF_ADDR")
+	// Discover peers instead of using CACHE_PEERS. A name starting with `_` is resolved as SRV records,
+	// otherwise as A records using the port of CACHE_SELF_ADDR (e.g. a headless service). Self is found by
+	// the IPs of the CACHE_SELF_ADDR host for A records, and by its host (or short hostname) and port for SRV.
+	CachePeerDNS = os.Getenv("CACHE_PEER_DNS")
+	// Discover peers from a file of comma or newline separated peers instead of using CACHE_PEERS,
+	// self must be listed exactly as CACHE_SELF_ADDR
+	CachePeerFile           = os.Getenv("CACHE_PEER_FILE")
+	CachePeerRefreshSeconds = GetEnvOrDefaultInt("CACHE_PEER_REFRESH_SECONDS", 10)
+	CacheBytes              = GetEnvOrDefaultInt("CACHE_BYTES", 100_000_000) // 100MB
+	CacheTTLSeconds         = GetEnvOrDefaultInt("CACHE_SECONDS", 10)
+	// How long past CACHE_SECONDS the last known good resolution can be served while refreshing in the background
+	CacheStaleSeconds = GetEnvOrDefaultInt("CACHE_STALE_SECONDS", 60)
+	// Required as the Authorization header to invalidate the cache, invalidation is disabled if not set
+	CacheInvalidateAuth = os.Getenv("CACHE_INVALIDATE_AUTH")
+	// How long unknown virtual buckets and denied keys are cached for
+	CacheNegativeSeconds = GetEnvOrDefaultInt("CACHE_NEGATIVE_SECONDS", 2)
+
+	// Memory budget for the cached folded state of IceDB logs
+	LogCacheBytes = GetEnvOrDefaultInt("LOG_CACHE_BYTES", 100_000_000) // 100MB
+
+	// Max log files fetched at once per read
+	LogFetchConcurrency = GetEnvOrDefaultInt("LOG_FETCH_CONCURRENCY", 16)
+
+	// Signs ListObjectsV2 continuation tokens, must be the same on every node
+	ListTokenSecret = GetEnvOrDefault("LIST_TOKEN_SECRET", AWSSecretKey)
+
+	// What to do when log files disagree on a column type (strict, widen, latest-wins), can be overridden per virtual bucket
+	SchemaPolicy = GetEnvOrDefault("SCHEMA_POLICY", "strict")
 
 	DevLookupPrefix = os.Getenv("DEV_LOOKUP_PREFIX")
 	DevLookupTimeMS = os.Getenv("DEV_LOOKUP_TIME_MS")